@@ -3,6 +3,7 @@ package jsonstream
 import (
 	"github.com/cbuschka/go-jsonstream/internal"
 	"io"
+	"math/big"
 )
 
 type Writer interface {
@@ -13,18 +14,309 @@ type Writer interface {
 	WriteKeyAndBooleanValue(key string, value bool) error
 	WriteKeyAndNumberValue(key string, value float64) error
 	WriteKeyAndIntegerValue(key string, value int) error
+	WriteKeyAndInt64Value(key string, value int64) error
+	WriteKeyAndUint64Value(key string, value uint64) error
+	WriteKeyAndBigIntValue(key string, value *big.Int) error
+	WriteKeyAndBigFloatValue(key string, value *big.Float) error
 	WriteKeyAndNullValue(key string) error
+	WriteKeyAndRawValue(key string, value []byte) error
+	WriteKeyAndValue(key string, v interface{}) error
 	WriteArrayStart() error
 	WriteArrayEnd() error
 	WriteStringValue(value string) error
 	WriteBooleanValue(value bool) error
 	WriteNumberValue(value float64) error
 	WriteIntegerValue(value int) error
+	WriteInt64Value(value int64) error
+	WriteUint64Value(value uint64) error
+	WriteBigIntValue(value *big.Int) error
+	WriteBigFloatValue(value *big.Float) error
 	WriteNullValue() error
+	WriteRawValue(value []byte) error
+	WriteValue(v interface{}) error
+	WriteToken(token Token) error
 	SetIndent(indent string)
+	SetPrefix(prefix string)
+	SetSpaceAfterColon(spaceAfterColon bool)
+	SetSpaceAfterComma(spaceAfterComma bool)
+	SetTrailingNewline(trailingNewline bool)
+	SetFormatOptions(options FormatOptions)
+	SetMultiDocument(multiDocument bool)
+	SetDocumentSeparator(separator string)
+	SetJSONTextSequence(jsonTextSequence bool)
+	DocumentCount() int64
+	SetCanonical(canonical bool)
+	SetSortKeys(sortKeys bool)
+	SetKeyComparator(compare func(a string, b string) int)
+	SetMaxSortBufferSize(maxSize int)
+	SetEscapeHTML(escapeHTML bool)
+	SetEscapeLineTerminators(escapeLineTerminators bool)
+	SetASCIIOnly(asciiOnly bool)
+	SetInvalidUTF8Policy(policy InvalidUTF8Policy)
+	SetNonFiniteNumberPolicy(policy NonFiniteNumberPolicy)
+	SetDuplicateKeyPolicy(policy DuplicateKeyPolicy)
+	SetMaxTrackedKeys(maxKeys int)
+	SetLimits(limits Limits)
+	SetNumberNotationThresholds(minFixed float64, maxFixed float64)
+	SetLargeIntegersAsStrings(largeIntegersAsStrings bool)
+	SetValidateRawValues(validateRawValues bool)
+	SetBufferSize(size int) error
+	SetFlushDepth(depth int)
+	Flush() error
+	Err() error
+	Finish() error
 	Close() error
+	CloseAndComplete() error
+	SetOwnsDestination(ownsDestination bool)
+	Abort(errValue interface{}) error
+	SetAbortMarkerKey(key string)
+	SetTokenFilter(filter TokenFilter)
+	TokenFilter() TokenFilter
 }
 
+type InvalidUTF8Policy = internal.InvalidUTF8Policy
+
+const (
+	// InvalidUTF8Replace replaces invalid UTF-8 in keys and string values by U+FFFD.
+	InvalidUTF8Replace = internal.IUP_REPLACE
+	// InvalidUTF8Error fails writing keys and string values containing invalid UTF-8.
+	InvalidUTF8Error = internal.IUP_ERROR
+)
+
 func NewWriter(wr io.Writer) Writer {
 	return Writer(internal.NewTokenWriter(wr))
 }
+
+// NewBufferedWriter returns a Writer buffering its output, which is written on Flush, Close
+// and whenever the buffer is full.
+func NewBufferedWriter(wr io.Writer) Writer {
+	tokenWriter := internal.NewTokenWriter(wr)
+	_ = tokenWriter.SetBufferSize(internal.DEFAULT_BUFFER_SIZE)
+	return Writer(tokenWriter)
+}
+
+// NewWriterWithOptions returns a Writer formatting its output according to options.
+func NewWriterWithOptions(wr io.Writer, options FormatOptions) Writer {
+	tokenWriter := internal.NewTokenWriter(wr)
+	tokenWriter.SetFormatOptions(options)
+	return Writer(tokenWriter)
+}
+
+// NewFilteredWriter makes every token written to wr pass the filters in order before it is checked and written.
+// The filters are installed on wr in front of the filter it has already, wr is returned.
+func NewFilteredWriter(wr Writer, filters ...TokenFilter) Writer {
+	if existing := wr.TokenFilter(); existing != nil {
+		filters = append(append([]TokenFilter{}, filters...), existing)
+	}
+	wr.SetTokenFilter(internal.NewFilterChain(filters...))
+	return wr
+}
+
+// TokenFilter transforms the tokens written to a Writer, passing the tokens to write on to emit.
+type TokenFilter = internal.TokenFilter
+
+// TokenFilterFunc adapts a function to a TokenFilter.
+type TokenFilterFunc = internal.TokenFilterFunc
+
+// TokenPath is the structural position of a token passed to a TokenFilter, like $.items[42].name.
+type TokenPath = internal.TokenPath
+
+// FilterChain is a TokenFilter passing the tokens through a sequence of filters.
+type FilterChain = internal.FilterChain
+
+func NewFilterChain(filters ...TokenFilter) *FilterChain {
+	return internal.NewFilterChain(filters...)
+}
+
+// DropFilter drops object members and array items at paths matching one of patterns,
+// like $.items[*].secret.
+func DropFilter(patterns ...string) TokenFilter {
+	return internal.DropFilter(patterns...)
+}
+
+// RenameFilter renames keys at paths matching the patterns of renames to the new names they map to.
+// If several patterns match, the one with the fewest wildcards wins, then the lexically smallest.
+func RenameFilter(renames map[string]string) TokenFilter {
+	return internal.RenameFilter(renames)
+}
+
+// MaskFilter replaces values at paths matching one of patterns by the string mask.
+func MaskFilter(mask string, patterns ...string) TokenFilter {
+	return internal.MaskFilter(mask, patterns...)
+}
+
+// ConvertFilter replaces scalar values at paths matching one of patterns by the result of convert.
+func ConvertFilter(convert func(token Token) (Token, error), patterns ...string) TokenFilter {
+	return internal.ConvertFilter(convert, patterns...)
+}
+
+// NumberToString converts number values to strings, for use with ConvertFilter.
+func NumberToString(token Token) (Token, error) {
+	return internal.NumberToString(token)
+}
+
+type FormatOptions = internal.FormatOptions
+
+var (
+	// CompactFormat writes json without any whitespace.
+	CompactFormat = internal.COMPACT_FORMAT
+	// PrettyFormat puts every key and item on a line of its own, indented by two spaces.
+	PrettyFormat = internal.PRETTY_FORMAT
+	// JSONLinesFormat puts every item of the top-level container on a single line of its own.
+	JSONLinesFormat = internal.JSON_LINES_FORMAT
+	// ReadableFormat is pretty printing with short scalar arrays kept on one line, long ones
+	// wrapped and values of objects aligned.
+	ReadableFormat = internal.READABLE_FORMAT
+)
+
+type NonFiniteNumberPolicy = internal.NonFiniteNumberPolicy
+
+const (
+	// NonFiniteNumberError fails writing NaN and ±Inf.
+	NonFiniteNumberError = internal.NFP_ERROR
+	// NonFiniteNumberNull writes NaN and ±Inf as null.
+	NonFiniteNumberNull = internal.NFP_NULL
+	// NonFiniteNumberString writes NaN and ±Inf as strings "NaN", "Infinity" and "-Infinity".
+	NonFiniteNumberString = internal.NFP_STRING
+)
+
+type DuplicateKeyPolicy = internal.DuplicateKeyPolicy
+
+const (
+	// DuplicateKeysAllowed passes duplicate keys through, Decode keeps the last value.
+	DuplicateKeysAllowed = internal.DKP_ALLOW
+	// DuplicateKeysRejected fails with a *DuplicateKeyError on a key seen before in the same object.
+	DuplicateKeysRejected = internal.DKP_ERROR
+	// DuplicateKeysDropped drops members with a key seen before in the same object, the first one wins.
+	DuplicateKeysDropped = internal.DKP_DROP
+)
+
+// Limits bound the documents written or read, a limit of 0 is disabled.
+type Limits = internal.Limits
+
+// MaxDepthError is returned if objects and arrays are nested deeper than Limits.MaxDepth.
+type MaxDepthError = internal.MaxDepthError
+
+// MaxStringLengthError is returned for a key or string value longer than Limits.MaxStringLength.
+type MaxStringLengthError = internal.MaxStringLengthError
+
+// MaxKeysPerObjectError is returned for an object with more than Limits.MaxKeysPerObject members.
+type MaxKeysPerObjectError = internal.MaxKeysPerObjectError
+
+// MaxTotalBytesError is returned if the document gets larger than Limits.MaxTotalBytes.
+type MaxTotalBytesError = internal.MaxTotalBytesError
+
+// SortBufferError is returned if an object is too large to be buffered for sorting its keys.
+type SortBufferError = internal.SortBufferError
+
+// DuplicateKeyError is returned for a duplicate key if duplicate keys are rejected.
+type DuplicateKeyError = internal.DuplicateKeyError
+
+type Reader interface {
+	ReadToken() (Token, error)
+	PeekToken() (Token, error)
+	Decode(v interface{}) error
+	DecodeNext(v interface{}) error
+	Path() string
+	SetTrackOffsets(trackOffsets bool)
+	SetMultiDocument(multiDocument bool)
+	Parse(h Handler) error
+	SetDuplicateKeyPolicy(policy DuplicateKeyPolicy)
+	SetMaxTrackedKeys(maxKeys int)
+	SetLimits(limits Limits)
+}
+
+func NewReader(rd io.Reader) Reader {
+	return Reader(internal.NewTokenReader(rd))
+}
+
+// Copy writes all tokens read from src to dst with constant memory, numbers keep their literal
+// text. dst is neither finished nor closed.
+func Copy(dst Writer, src Reader) error {
+	return internal.CopyTokens(dst, src)
+}
+
+// Handler receives the tokens of a document from Parse, numbers are reported with their literal text.
+type Handler = internal.Handler
+
+// DocumentEndHandler is implemented by handlers interested in the end of every top-level value
+// in multi document mode.
+type DocumentEndHandler = internal.DocumentEndHandler
+
+var (
+	// SkipValue returned by Handler.OnObjectStart, OnArrayStart or OnKey skips the object,
+	// array or member value.
+	SkipValue = internal.SKIP_VALUE
+	// Stop returned by a Handler method ends Parse without error.
+	Stop = internal.STOP
+)
+
+// Parse reads a json document from rd and pushes its tokens to h.
+func Parse(rd io.Reader, h Handler) error {
+	return internal.NewTokenReader(rd).Parse(h)
+}
+
+// SeqReader reads a json text sequence (RFC 7464) record by record, skipping malformed records.
+type SeqReader interface {
+	Next() error
+	ReadToken() (Token, error)
+	PeekToken() (Token, error)
+	Decode(v interface{}) error
+	Path() string
+	RecordOffset() int64
+	SkippedBytes() int64
+	SetDuplicateKeyPolicy(policy DuplicateKeyPolicy)
+	SetLimits(limits Limits)
+}
+
+func NewSeqReader(rd io.Reader) SeqReader {
+	return SeqReader(internal.NewSeqReader(rd))
+}
+
+// LineReader reads newline delimited json (NDJSON, JSON Lines) line by line, every line is an
+// independent document.
+type LineReader interface {
+	Next() error
+	ReadToken() (Token, error)
+	PeekToken() (Token, error)
+	Decode(v interface{}) error
+	DecodeNext(v interface{}) error
+	Path() string
+	Line() int
+	SetSkipBadLines(onRejected func(line int, record []byte, err error))
+	SetDuplicateKeyPolicy(policy DuplicateKeyPolicy)
+	SetLimits(limits Limits)
+}
+
+func NewLineReader(rd io.Reader) LineReader {
+	return LineReader(internal.NewLineReader(rd))
+}
+
+// LineError is returned by LineReader for a line that cannot be read or decoded, syntax errors
+// are returned as *SyntaxError with the line number of the file.
+type LineError = internal.LineError
+
+type Token = internal.Token
+
+// SyntaxError is returned by Reader for malformed input, use errors.As to access position and path.
+type SyntaxError = internal.SyntaxError
+
+type TokenType = internal.TokenType
+
+const (
+	TT_OBJECT_START  = internal.TT_OBJECT_START
+	TT_OBJECT_END    = internal.TT_OBJECT_END
+	TT_ARRAY_START   = internal.TT_ARRAY_START
+	TT_ARRAY_END     = internal.TT_ARRAY_END
+	TT_KEY           = internal.TT_KEY
+	TT_COLON         = internal.TT_COLON
+	TT_COMMA         = internal.TT_COMMA
+	TT_STRING_VALUE  = internal.TT_STRING_VALUE
+	TT_NULL_VALUE    = internal.TT_NULL_VALUE
+	TT_TRUE_VALUE    = internal.TT_TRUE_VALUE
+	TT_FALSE_VALUE   = internal.TT_FALSE_VALUE
+	TT_NUMBER_VALUE  = internal.TT_NUMBER_VALUE
+	TT_INTEGER_VALUE = internal.TT_INTEGER_VALUE
+	TT_RAW_VALUE     = internal.TT_RAW_VALUE
+	TT_DOCUMENT_END  = internal.TT_DOCUMENT_END
+)
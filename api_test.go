@@ -2,9 +2,14 @@ package jsonstream
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/cbuschka/go-jsonstream/internal"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -139,3 +144,344 @@ func TestFailsOnCloseIfNotInEndState(t *testing.T) {
 	err = wr.Close()
 	assert.Equal(t, fmt.Errorf("not in end state"), err)
 }
+
+func TestWritesEscapedObjectViaWriter(t *testing.T) {
+	expectedJson := "{\"\\u003ckey\\u003e\":\"\\\"quoted\\\"\"}"
+	testProducesJsonViaWriter(t, expectedJson, func(wr Writer) error {
+		wr.SetEscapeHTML(true)
+		err := wr.WriteObjectStart()
+		if err != nil {
+			return err
+		}
+		err = wr.WriteKeyAndStringValue("<key>", "\"quoted\"")
+		if err != nil {
+			return err
+		}
+		return wr.WriteObjectEnd()
+	})
+}
+
+func TestReadsObjectViaReader(t *testing.T) {
+	rd := NewReader(strings.NewReader("{\"key\":\"value\"}"))
+
+	tokens := []Token{}
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		tokens = append(tokens, token)
+	}
+
+	assert.Equal(t, []Token{{Type: TT_OBJECT_START, Value: ""}, {Type: TT_KEY, Value: "key"}, {Type: TT_STRING_VALUE, Value: "value"}, {Type: TT_OBJECT_END, Value: ""}}, tokens)
+}
+
+func TestReportsSyntaxErrorViaReader(t *testing.T) {
+	rd := NewReader(strings.NewReader("[1,\n2,,3]"))
+
+	var err error
+	for err == nil {
+		_, err = rd.ReadToken()
+	}
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, 2, syntaxErr.Line)
+	assert.Equal(t, 3, syntaxErr.Column)
+	assert.Equal(t, "$[1]", syntaxErr.Path)
+}
+
+func TestWritesNumberViaWriter(t *testing.T) {
+	expectedJson := "{\"a\":1.5,\"b\":null}"
+	testProducesJsonViaWriter(t, expectedJson, func(wr Writer) error {
+		wr.SetNonFiniteNumberPolicy(NonFiniteNumberNull)
+		err := wr.WriteObjectStart()
+		if err != nil {
+			return err
+		}
+		err = wr.WriteKeyAndNumberValue("a", 1.5)
+		if err != nil {
+			return err
+		}
+		err = wr.WriteKeyAndNumberValue("b", math.NaN())
+		if err != nil {
+			return err
+		}
+		return wr.WriteObjectEnd()
+	})
+}
+
+func TestWritesWideIntegersViaWriter(t *testing.T) {
+	expectedJson := "{\"id\":18446744073709551615,\"amount\":\"123456789012345678901234567890\"}"
+	testProducesJsonViaWriter(t, expectedJson, func(wr Writer) error {
+		err := wr.WriteObjectStart()
+		if err != nil {
+			return err
+		}
+		err = wr.WriteKeyAndUint64Value("id", math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+		wr.SetLargeIntegersAsStrings(true)
+		err = wr.WriteKeyAndBigIntValue("amount", amount)
+		if err != nil {
+			return err
+		}
+		return wr.WriteObjectEnd()
+	})
+}
+
+func TestWritesRawValueViaWriter(t *testing.T) {
+	expectedJson := "[{\"cached\":true},1]"
+	testProducesJsonViaWriter(t, expectedJson, func(wr Writer) error {
+		err := wr.WriteArrayStart()
+		if err != nil {
+			return err
+		}
+		err = wr.WriteRawValue([]byte("{\"cached\": true}"))
+		if err != nil {
+			return err
+		}
+		err = wr.WriteIntegerValue(1)
+		if err != nil {
+			return err
+		}
+		return wr.WriteArrayEnd()
+	})
+}
+
+func TestWritesValueViaWriter(t *testing.T) {
+	type record struct {
+		Name  string `json:"name"`
+		Email string `json:"email,omitempty"`
+	}
+
+	expectedJson := "{\"records\":[{\"name\":\"a\"}]}"
+	testProducesJsonViaWriter(t, expectedJson, func(wr Writer) error {
+		err := wr.WriteObjectStart()
+		if err != nil {
+			return err
+		}
+		err = wr.WriteKeyAndValue("records", []record{{Name: "a"}})
+		if err != nil {
+			return err
+		}
+		return wr.WriteObjectEnd()
+	})
+}
+
+func TestDecodesArrayElementsViaReader(t *testing.T) {
+	rd := NewReader(strings.NewReader("[{\"name\":\"a\"},{\"name\":\"b\"}]"))
+
+	names := []string{}
+	for {
+		var record struct {
+			Name string `json:"name"`
+		}
+		err := rd.DecodeNext(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		names = append(names, record.Name)
+	}
+
+	assert.Equal(t, []string{"a", "b"}, names)
+}
+
+func TestWritesBufferedViaWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewBufferedWriter(buf)
+
+	err := wr.WriteStringValue("value")
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	assert.Equal(t, "", buf.String())
+
+	err = wr.Close()
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+	assert.Equal(t, "\"value\"", buf.String())
+}
+
+func TestWritesWithFormatOptions(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriterWithOptions(buf, ReadableFormat)
+
+	assert.NoError(t, wr.WriteValue(map[string]interface{}{"id": 1, "tags": []string{"a", "b"}}))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\n  \"id\": 1,\n  \"tags\": [\"a\", \"b\"]\n}", buf.String())
+}
+
+func TestRejectsDuplicateKeysViaWriter(t *testing.T) {
+	wr := NewWriter(new(bytes.Buffer))
+	wr.SetDuplicateKeyPolicy(DuplicateKeysRejected)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndNullValue("a"))
+	err := wr.WriteKeyAndNullValue("a")
+
+	var duplicateKeyErr *DuplicateKeyError
+	assert.True(t, errors.As(err, &duplicateKeyErr))
+}
+
+func TestFailsReadingBeyondLimits(t *testing.T) {
+	rd := NewReader(strings.NewReader("[[[1]]]"))
+	rd.SetLimits(Limits{MaxDepth: 2})
+
+	var v interface{}
+	err := rd.Decode(&v)
+
+	var depthErr *MaxDepthError
+	assert.True(t, errors.As(err, &depthErr))
+}
+
+func TestWritesCanonicalViaWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriter(buf)
+	wr.SetCanonical(true)
+
+	assert.NoError(t, wr.WriteValue(map[string]interface{}{"b": 1.50, "a": "< >"}))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"a\":\"< >\",\"b\":1.5}", buf.String())
+}
+
+func TestRoundTripsJSONTextSequence(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriter(buf)
+	wr.SetJSONTextSequence(true)
+	assert.NoError(t, wr.WriteValue([]int{1}))
+	assert.NoError(t, wr.WriteValue("x"))
+	assert.NoError(t, wr.Close())
+
+	rd := NewSeqReader(strings.NewReader(buf.String()))
+	var numbers []int
+	assert.NoError(t, rd.Next())
+	assert.NoError(t, rd.Decode(&numbers))
+	var text string
+	assert.NoError(t, rd.Next())
+	assert.NoError(t, rd.Decode(&text))
+	assert.Equal(t, io.EOF, rd.Next())
+
+	assert.Equal(t, []int{1}, numbers)
+	assert.Equal(t, "x", text)
+}
+
+func TestSkipsBadLinesViaLineReader(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("1\nx\n3\n"))
+	rejected := []int{}
+	rd.SetSkipBadLines(func(line int, record []byte, err error) {
+		rejected = append(rejected, line)
+	})
+
+	numbers := []int{}
+	for {
+		var number int
+		err := rd.DecodeNext(&number)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		numbers = append(numbers, number)
+	}
+
+	assert.Equal(t, []int{1, 3}, numbers)
+	assert.Equal(t, []int{2}, rejected)
+}
+
+// keyCollector collects the keys of the top-level object without descending into values.
+type keyCollector struct {
+	keys []string
+}
+
+func (c *keyCollector) OnObjectStart() error        { return nil }
+func (c *keyCollector) OnObjectEnd() error          { return Stop }
+func (c *keyCollector) OnKey(key string) error      { c.keys = append(c.keys, key); return SkipValue }
+func (c *keyCollector) OnArrayStart() error         { return nil }
+func (c *keyCollector) OnArrayEnd() error           { return nil }
+func (c *keyCollector) OnString(value string) error { return nil }
+func (c *keyCollector) OnNumber(value string) error { return nil }
+func (c *keyCollector) OnBool(value bool) error     { return nil }
+func (c *keyCollector) OnNull() error               { return nil }
+
+func TestParsesWithHandler(t *testing.T) {
+	collector := &keyCollector{}
+
+	err := Parse(strings.NewReader(`{"a":{"b":1},"c":[2]} trailing`), collector)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "c"}, collector.keys)
+}
+
+func TestCopiesTokensPreservingNumbers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriterWithOptions(buf, PrettyFormat)
+
+	err := Copy(wr, NewReader(strings.NewReader(`{"a" : [1.50, 1e400, 12345678901234567890], "b":"é"}`)))
+
+	assert.NoError(t, err)
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, "{\n  \"a\": [\n    1.50,\n    1e400,\n    12345678901234567890\n  ],\n  \"b\": \"é\"\n}", buf.String())
+}
+
+func TestCopiesConcatenatedDocuments(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriter(buf)
+	wr.SetMultiDocument(true)
+	rd := NewReader(strings.NewReader("{\"a\": 1}\n\n[ 2 ]"))
+	rd.SetMultiDocument(true)
+
+	assert.NoError(t, Copy(wr, rd))
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, "{\"a\":1}\n[2]\n", buf.String())
+}
+
+func TestFailsCopyingMalformedInput(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriter(buf)
+
+	err := Copy(wr, NewReader(strings.NewReader(`[1,]`)))
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+}
+
+func TestWritesThroughFilterChain(t *testing.T) {
+	buf := new(bytes.Buffer)
+	chain := NewFilterChain(DropFilter("$.internal")).
+		Then(RenameFilter(map[string]string{"$.pwd": "password"})).
+		Then(MaskFilter("***", "$.password")).
+		Then(ConvertFilter(NumberToString, "$.id"))
+	wr := NewFilteredWriter(NewWriter(buf), chain)
+
+	assert.NoError(t, wr.WriteValue(map[string]interface{}{"id": 7, "internal": []int{1}, "name": "n", "pwd": "secret"}))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, `{"id":"7","name":"n","password":"***"}`, buf.String())
+}
+
+func TestKeepsFilterOfFilteredWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewWriter(buf)
+	wr.SetTokenFilter(MaskFilter("***", "$.password"))
+	wr = NewFilteredWriter(wr, RenameFilter(map[string]string{"$.pwd": "password"}))
+
+	assert.NoError(t, wr.WriteValue(map[string]interface{}{"pwd": "secret"}))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, `{"password":"***"}`, buf.String())
+}
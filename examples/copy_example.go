@@ -0,0 +1,17 @@
+package examples
+
+import (
+	"github.com/cbuschka/go-jsonstream"
+	"os"
+)
+
+func runCopy() error {
+
+	wr := jsonstream.NewWriterWithOptions(os.Stdout, jsonstream.PrettyFormat)
+	wr.SetOwnsDestination(false)
+	if err := jsonstream.Copy(wr, jsonstream.NewReader(os.Stdin)); err != nil {
+		return err
+	}
+
+	return wr.Close()
+}
@@ -0,0 +1,29 @@
+package examples
+
+import (
+	"fmt"
+	"github.com/cbuschka/go-jsonstream"
+	"io"
+	"os"
+)
+
+type Record struct {
+	Name string `json:"name"`
+}
+
+func runDecode() error {
+
+	rd := jsonstream.NewReader(os.Stdin)
+	for {
+		var record Record
+		err := rd.DecodeNext(&record)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s\n", record.Name)
+	}
+}
@@ -8,9 +8,7 @@ import (
 func run() error {
 
 	wr := jsonstream.NewWriter(os.Stdout)
-	defer func() {
-		_ = wr.Close()
-	}()
+	wr.SetOwnsDestination(false)
 	if err := wr.WriteObjectStart(); err != nil {
 		return err
 	}
@@ -23,5 +21,5 @@ func run() error {
 		return err
 	}
 
-	return nil
+	return wr.Close()
 }
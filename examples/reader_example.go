@@ -0,0 +1,24 @@
+package examples
+
+import (
+	"fmt"
+	"github.com/cbuschka/go-jsonstream"
+	"io"
+	"os"
+)
+
+func runReader() error {
+
+	rd := jsonstream.NewReader(os.Stdin)
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%s %s\n", token.Type.Name(), token.Value)
+	}
+}
@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"io"
+)
+
+type tokenSource interface {
+	ReadToken() (Token, error)
+}
+
+type tokenSink interface {
+	WriteToken(token Token) error
+}
+
+// CopyTokens writes all tokens read from src to dst until src is exhausted. Numbers keep their
+// literal text and nothing but the current token is held, so memory use does not depend on the
+// size of the input.
+func CopyTokens(dst tokenSink, src tokenSource) error {
+	for {
+		token, err := src.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = dst.WriteToken(token)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package internal
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestCopiesTokens(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	err := CopyTokens(wr, NewTokenReader(strings.NewReader(" [ 1 , {\"a\" : -0.0e1} , \"\\/\" ] ")))
+
+	assert.NoError(t, err)
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, "[1,{\"a\":-0.0e1},\"/\"]", buf.String())
+}
@@ -0,0 +1,516 @@
+package internal
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// Decode reads the next complete value and stores it in the value pointed to by v the way
+// encoding/json would unmarshal it. Only this value is held in memory. If the value does
+// not match the type of v, it is skipped and an error is returned, so reading can go on.
+func (r *TokenReader) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode target must be a non-nil pointer, got %T", v)
+	}
+
+	token, err := r.ReadToken()
+	if err == nil && token.Type == TT_DOCUMENT_END {
+		// in multi document mode the boundary after the previous value is skipped
+		token, err = r.ReadToken()
+	}
+	if err != nil {
+		return err
+	}
+
+	r.decodeErr = nil
+	err = r.decodeValue(token, rv.Elem(), false)
+	if err != nil {
+		return err
+	}
+
+	err = r.decodeErr
+	r.decodeErr = nil
+	return err
+}
+
+// DecodeNext decodes the next element of the current array into v. If the reader is not
+// inside an array, the next token must start an array, which is entered first.
+// io.EOF is returned after the end of the array has been consumed.
+func (r *TokenReader) DecodeNext(v interface{}) error {
+	switch r.callerState() {
+	case TRS_IN_ARRAY, TRS_IN_ARRAY_ITEM_SEEN, TRS_IN_ARRAY_COMMA_SEEN:
+	default:
+		token, err := r.ReadToken()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		if token.Type != TT_ARRAY_START {
+			return fmt.Errorf("expected TT_ARRAY_START, got %s at %s", token.Type.Name(), r.Path())
+		}
+	}
+
+	token, err := r.PeekToken()
+	if err != nil {
+		return err
+	}
+
+	if token.Type == TT_ARRAY_END {
+		_, _ = r.ReadToken()
+		return io.EOF
+	}
+
+	return r.Decode(v)
+}
+
+// skipValue consumes the remainder of the value started by token.
+func (r *TokenReader) skipValue(token Token) error {
+	depth := 0
+	for {
+		switch token.Type {
+		case TT_OBJECT_START, TT_ARRAY_START:
+			depth++
+		case TT_OBJECT_END, TT_ARRAY_END:
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+
+		var err error
+		token, err = r.ReadToken()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// captureValue returns the encoded json of the value started by token.
+func (r *TokenReader) captureValue(token Token) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	depth := 0
+	for {
+		switch token.Type {
+		case TT_OBJECT_START, TT_ARRAY_START:
+			depth++
+		case TT_OBJECT_END, TT_ARRAY_END:
+			depth--
+		}
+
+		err := wr.WriteToken(token)
+		if err != nil {
+			return nil, err
+		}
+
+		if depth == 0 {
+			return buf.Bytes(), nil
+		}
+
+		token, err = r.ReadToken()
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// recordDecodeErr records the first error of a value that could not be stored, decoding goes on
+// with the next value and Decode returns the error in the end.
+func (r *TokenReader) recordDecodeErr(err error) {
+	if r.decodeErr == nil {
+		r.decodeErr = err
+	}
+}
+
+func (r *TokenReader) mismatch(token Token, v reflect.Value) error {
+	r.recordDecodeErr(fmt.Errorf("cannot decode %s into %s at %s", token.Type.Name(), v.Type(), r.Path()))
+	return r.skipValue(token)
+}
+
+func (r *TokenReader) decodeValue(token Token, v reflect.Value, quoted bool) error {
+	if token.Type == TT_NULL_VALUE {
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	for {
+		if v.Kind() != reflect.Ptr && v.CanAddr() {
+			ptrType := reflect.PtrTo(v.Type())
+			if ptrType.Implements(jsonUnmarshalerType) {
+				value, err := r.captureValue(token)
+				if err != nil {
+					return err
+				}
+				r.recordDecodeErr(v.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(value))
+				return nil
+			}
+			if token.Type == TT_STRING_VALUE && ptrType.Implements(textUnmarshalerType) {
+				r.recordDecodeErr(v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(token.Value)))
+				return nil
+			}
+		}
+
+		if v.Kind() != reflect.Ptr {
+			break
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+
+	if quoted && token.Type == TT_STRING_VALUE {
+		return r.decodeQuoted(token, v)
+	}
+
+	switch token.Type {
+	case TT_OBJECT_START:
+		switch {
+		case v.Kind() == reflect.Struct:
+			return r.decodeStruct(v)
+		case v.Kind() == reflect.Map:
+			return r.decodeMap(token, v)
+		case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+			m := map[string]interface{}{}
+			err := r.decodeMap(token, reflect.ValueOf(m))
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(m))
+			return nil
+		}
+	case TT_ARRAY_START:
+		switch {
+		case v.Kind() == reflect.Slice || v.Kind() == reflect.Array:
+			return r.decodeArray(v)
+		case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+			s := reflect.ValueOf(&[]interface{}{}).Elem()
+			err := r.decodeArray(s)
+			if err != nil {
+				return err
+			}
+			v.Set(s)
+			return nil
+		}
+	case TT_STRING_VALUE:
+		switch {
+		case v.Kind() == reflect.String && v.Type() != jsonNumberType:
+			v.SetString(token.Value)
+			return nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			value, err := base64.StdEncoding.DecodeString(token.Value)
+			if err != nil {
+				r.recordDecodeErr(fmt.Errorf("invalid base64 at %s: %w", r.Path(), err))
+				return nil
+			}
+			v.SetBytes(value)
+			return nil
+		case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+			v.Set(reflect.ValueOf(token.Value))
+			return nil
+		}
+	case TT_TRUE_VALUE, TT_FALSE_VALUE:
+		switch {
+		case v.Kind() == reflect.Bool:
+			v.SetBool(token.Type == TT_TRUE_VALUE)
+			return nil
+		case v.Kind() == reflect.Interface && v.NumMethod() == 0:
+			v.Set(reflect.ValueOf(token.Type == TT_TRUE_VALUE))
+			return nil
+		}
+	case TT_NUMBER_VALUE, TT_INTEGER_VALUE:
+		return r.decodeNumber(token, v)
+	}
+
+	return r.mismatch(token, v)
+}
+
+func (r *TokenReader) decodeNumber(token Token, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		value, err := strconv.ParseInt(token.Value, 10, v.Type().Bits())
+		if err != nil {
+			r.recordDecodeErr(fmt.Errorf("cannot decode number %s into %s at %s", token.Value, v.Type(), r.Path()))
+			return nil
+		}
+		v.SetInt(value)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		value, err := strconv.ParseUint(token.Value, 10, v.Type().Bits())
+		if err != nil {
+			r.recordDecodeErr(fmt.Errorf("cannot decode number %s into %s at %s", token.Value, v.Type(), r.Path()))
+			return nil
+		}
+		v.SetUint(value)
+		return nil
+	case reflect.Float32, reflect.Float64:
+		value, err := strconv.ParseFloat(token.Value, v.Type().Bits())
+		if err != nil {
+			r.recordDecodeErr(fmt.Errorf("cannot decode number %s into %s at %s", token.Value, v.Type(), r.Path()))
+			return nil
+		}
+		v.SetFloat(value)
+		return nil
+	case reflect.String:
+		if v.Type() == jsonNumberType {
+			v.SetString(token.Value)
+			return nil
+		}
+	case reflect.Interface:
+		if v.NumMethod() == 0 {
+			value, err := strconv.ParseFloat(token.Value, 64)
+			if err != nil {
+				r.recordDecodeErr(fmt.Errorf("cannot decode number %s into %s at %s", token.Value, v.Type(), r.Path()))
+				return nil
+			}
+			v.Set(reflect.ValueOf(value))
+			return nil
+		}
+	}
+
+	return r.mismatch(token, v)
+}
+
+// decodeQuoted decodes a value encoded as string because of the `json:",string"` option.
+func (r *TokenReader) decodeQuoted(token Token, v reflect.Value) error {
+	inner := NewTokenReader(strings.NewReader(token.Value))
+	innerToken, err := inner.ReadToken()
+	if err == nil && innerToken.Type != TT_OBJECT_START && innerToken.Type != TT_ARRAY_START {
+		_, err = inner.ReadToken()
+		if err == io.EOF {
+			err = inner.decodeValue(innerToken, v, false)
+			if err == nil && inner.decodeErr == nil {
+				return nil
+			}
+		}
+	}
+
+	r.recordDecodeErr(fmt.Errorf("invalid quoted value %q for %s at %s", token.Value, v.Type(), r.Path()))
+	return nil
+}
+
+func (r *TokenReader) decodeStruct(v reflect.Value) error {
+	fields := structFields(v.Type())
+	for {
+		token, err := r.ReadToken()
+		if err != nil {
+			return err
+		}
+
+		if token.Type == TT_OBJECT_END {
+			return nil
+		}
+
+		field, found := lookupField(fields, token.Value)
+		valueToken, err := r.ReadToken()
+		if err != nil {
+			return err
+		}
+
+		if !found {
+			err := r.skipValue(valueToken)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		fieldValue, allocErr := fieldByIndexAlloc(v, field.index)
+		if allocErr != nil {
+			r.recordDecodeErr(fmt.Errorf("%v at %s", allocErr, r.Path()))
+			err := r.skipValue(valueToken)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = r.decodeValue(valueToken, fieldValue, field.quoted)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// lookupField finds the field for key, preferring an exact match over a case-insensitive one.
+func lookupField(fields []structField, key string) (structField, bool) {
+	for _, field := range fields {
+		if field.name == key {
+			return field, true
+		}
+	}
+	for _, field := range fields {
+		if strings.EqualFold(field.name, key) {
+			return field, true
+		}
+	}
+
+	return structField{}, false
+}
+
+// fieldByIndexAlloc returns the field of struct value v, allocating nil embedded pointers on the way.
+// Like encoding/json it fails for a nil pointer to an unexported embedded struct, which cannot be set.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set embedded pointer to unexported struct %s", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, nil
+}
+
+func (r *TokenReader) decodeMap(token Token, v reflect.Value) error {
+	keyType := v.Type().Key()
+	switch keyType.Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !reflect.PtrTo(keyType).Implements(textUnmarshalerType) {
+			return r.mismatch(token, v)
+		}
+	}
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+
+	for {
+		token, err := r.ReadToken()
+		if err != nil {
+			return err
+		}
+
+		if token.Type == TT_OBJECT_END {
+			return nil
+		}
+
+		key, keyErr := r.mapKey(token.Value, keyType)
+		valueToken, err := r.ReadToken()
+		if err != nil {
+			return err
+		}
+
+		if keyErr != nil {
+			r.recordDecodeErr(keyErr)
+			err := r.skipValue(valueToken)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		value := reflect.New(v.Type().Elem()).Elem()
+		err = r.decodeValue(valueToken, value, false)
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(key, value)
+	}
+}
+
+func (r *TokenReader) mapKey(key string, keyType reflect.Type) (reflect.Value, error) {
+	if reflect.PtrTo(keyType).Implements(textUnmarshalerType) {
+		value := reflect.New(keyType)
+		err := value.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key))
+		return value.Elem(), err
+	}
+
+	value := reflect.New(keyType).Elem()
+	switch keyType.Kind() {
+	case reflect.String:
+		value.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, keyType.Bits())
+		if err != nil {
+			return value, fmt.Errorf("cannot decode key %q into %s at %s", key, keyType, r.Path())
+		}
+		value.SetInt(n)
+	default:
+		n, err := strconv.ParseUint(key, 10, keyType.Bits())
+		if err != nil {
+			return value, fmt.Errorf("cannot decode key %q into %s at %s", key, keyType, r.Path())
+		}
+		value.SetUint(n)
+	}
+
+	return value, nil
+}
+
+func (r *TokenReader) decodeArray(v reflect.Value) error {
+	i := 0
+	for {
+		token, err := r.ReadToken()
+		if err != nil {
+			return err
+		}
+
+		if token.Type == TT_ARRAY_END {
+			break
+		}
+
+		if v.Kind() == reflect.Slice {
+			if i >= v.Len() {
+				v.Set(reflect.Append(v, reflect.Zero(v.Type().Elem())))
+			}
+		} else if i >= v.Len() {
+			err := r.skipValue(token)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = r.decodeValue(token, v.Index(i), false)
+		if err != nil {
+			return err
+		}
+		i++
+	}
+
+	if v.Kind() == reflect.Slice {
+		if v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+		}
+		v.SetLen(i)
+	} else {
+		for ; i < v.Len(); i++ {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+		}
+	}
+
+	return nil
+}
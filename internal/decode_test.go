@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Record struct {
+	Base
+	Name     string          `json:"name"`
+	Count    int             `json:"count,string"`
+	Score    float64         `json:"score"`
+	Tags     []string        `json:"tags"`
+	Labels   map[string]int  `json:"labels"`
+	Child    *Record         `json:"child"`
+	Created  time.Time       `json:"created"`
+	Amount   *big.Int        `json:"amount"`
+	Raw      json.RawMessage `json:"raw"`
+	Any      interface{}     `json:"any"`
+	Data     []byte          `json:"data"`
+	Ignored  string          `json:"-"`
+	ByCode   map[int]string  `json:"byCode"`
+	Untagged bool
+}
+
+func TestDecodesStructWithTags(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`{"id": 7, "name": "a", "count": "3", "score": 1.5, "tags": ["x", "y"],
+		"labels": {"k": 1}, "child": {"name": "b"}, "created": "2021-03-04T05:06:07Z", "amount": 123456789012345678901234567890,
+		"raw": {"keep": [1, 2.50]}, "any": {"list": [1, "s", true, null]}, "data": "aGVsbG8=", "Ignored": "x", "byCode": {"200": "ok"},
+		"untagged": true, "unknown": {"deep": [1, {"x": 2}]}}`))
+
+	var record Record
+	err := rd.Decode(&record)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	assert.Equal(t, Record{Base: Base{ID: 7}, Name: "a", Count: 3, Score: 1.5, Tags: []string{"x", "y"}, Labels: map[string]int{"k": 1},
+		Child: &Record{Name: "b"}, Created: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC), Amount: amount,
+		Raw: json.RawMessage(`{"keep":[1,2.50]}`), Any: map[string]interface{}{"list": []interface{}{1.0, "s", true, nil}},
+		Data: []byte("hello"), ByCode: map[int]string{200: "ok"}, Untagged: true}, record)
+
+	_, err = rd.ReadToken()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodesArrayElementsOneByOne(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`[{"id": 1}, {"id": 2, "comment": "c"}, {"id": 3}]`))
+
+	records := []Base{}
+	for {
+		var record Base
+		err := rd.DecodeNext(&record)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		records = append(records, record)
+	}
+
+	assert.Equal(t, []Base{{ID: 1}, {ID: 2, Comment: "c"}, {ID: 3}}, records)
+	_, err := rd.ReadToken()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecodesArrayElementsOfNestedArray(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`{"total": 2, "items": [[1, 2], [3]], "next": null}`))
+
+	for {
+		token, err := rd.ReadToken()
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		if token.Type == TT_KEY && token.Value == "items" {
+			break
+		}
+	}
+
+	items := [][]int{}
+	for {
+		var item []int
+		err := rd.DecodeNext(&item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		items = append(items, item)
+	}
+
+	assert.Equal(t, [][]int{{1, 2}, {3}}, items)
+	token, err := rd.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, Token{Type: TT_KEY, Value: "next"}, token)
+}
+
+func TestSkipsMismatchingElementAndGoesOn(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`[{"id": 1}, {"id": "two", "comment": "c"}, {"id": 3}]`))
+
+	var record Base
+	assert.NoError(t, rd.DecodeNext(&record))
+	err := rd.DecodeNext(&record)
+	assert.EqualError(t, err, "cannot decode TT_STRING_VALUE into int64 at $[1].id")
+	assert.Equal(t, "c", record.Comment)
+	record = Base{}
+	assert.NoError(t, rd.DecodeNext(&record))
+	assert.Equal(t, Base{ID: 3}, record)
+	assert.Equal(t, io.EOF, rd.DecodeNext(&record))
+}
+
+func TestFailsOnNumberOverflow(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`[300]`))
+
+	var values []int8
+	err := rd.Decode(&values)
+	assert.EqualError(t, err, "cannot decode number 300 into int8 at $[0]")
+}
+
+func TestFailsDecodeNextOutsideOfArray(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`{"a": 1}`))
+
+	var value int
+	err := rd.DecodeNext(&value)
+	assert.EqualError(t, err, "expected TT_ARRAY_START, got TT_OBJECT_START at $")
+}
+
+func TestFailsDecodeIntoNonPointer(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`1`))
+
+	var value int
+	err := rd.Decode(value)
+	assert.EqualError(t, err, "decode target must be a non-nil pointer, got int")
+}
+
+func TestDecodesLikeEncodingJson(t *testing.T) {
+	input := `{"id": 1, "name": "n", "count": "5", "tags": null, "labels": {"a": 1, "b": 2}, "child": {"id": 2, "child": null}}`
+	var expected Record
+	err := json.Unmarshal([]byte(input), &expected)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	var record Record
+	assert.NoError(t, NewTokenReader(strings.NewReader(input)).Decode(&record))
+	assert.Equal(t, expected, record)
+}
+
+type embeddedInner struct {
+	X int `json:"x"`
+}
+
+type withUnexportedEmbeddedPointer struct {
+	*embeddedInner
+	Y int `json:"y"`
+}
+
+func TestFailsOnNilPointerToUnexportedEmbeddedStruct(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`{"x": {"a": 1}, "y": 2}`))
+
+	var value withUnexportedEmbeddedPointer
+	err := rd.Decode(&value)
+	assert.EqualError(t, err, "cannot set embedded pointer to unexported struct internal.embeddedInner at $.x")
+	assert.Equal(t, 2, value.Y)
+}
+
+func TestDecodesIntoAllocatedUnexportedEmbeddedStruct(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(`{"x": 1, "y": 2}`))
+
+	value := withUnexportedEmbeddedPointer{embeddedInner: &embeddedInner{}}
+	assert.NoError(t, rd.Decode(&value))
+	assert.Equal(t, 1, value.X)
+	assert.Equal(t, 2, value.Y)
+}
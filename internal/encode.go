@@ -0,0 +1,358 @@
+package internal
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	bigIntType        = reflect.TypeOf(big.Int{})
+	bigFloatType      = reflect.TypeOf(big.Float{})
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+)
+
+// WriteValue writes v the way encoding/json would marshal it: structs honor `json` tags
+// including omitempty and string, map keys are sorted, json.Marshaler and
+// encoding.TextMarshaler are respected.
+func (t *TokenWriter) WriteValue(v interface{}) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	err := t.writeReflectValue(reflect.ValueOf(v), false)
+	if err != nil {
+		return t.fail(err)
+	}
+
+	return nil
+}
+
+func (t *TokenWriter) WriteKeyAndValue(key string, v interface{}) error {
+	err := t.WriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteValue(v)
+}
+
+func (t *TokenWriter) writeReflectValue(v reflect.Value, quoted bool) error {
+	if !v.IsValid() {
+		return t.WriteNullValue()
+	}
+
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return t.WriteNullValue()
+	}
+
+	handled, err := t.writeSpecialValue(v)
+	if handled || err != nil {
+		return err
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if quoted {
+			return t.WriteStringValue(strconv.FormatBool(v.Bool()))
+		}
+		return t.WriteBooleanValue(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if quoted {
+			return t.WriteStringValue(strconv.FormatInt(v.Int(), 10))
+		}
+		return t.WriteInt64Value(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if quoted {
+			return t.WriteStringValue(strconv.FormatUint(v.Uint(), 10))
+		}
+		return t.WriteUint64Value(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		bitSize := v.Type().Bits()
+		// NaN and ±Inf are handled by the non finite number policy, quoted or not
+		if quoted && !math.IsNaN(v.Float()) && !math.IsInf(v.Float(), 0) {
+			return t.WriteStringValue(string(appendFloat(nil, v.Float(), bitSize, &t.number)))
+		}
+		return t.writeFloatValue(v.Float(), bitSize)
+	case reflect.String:
+		if quoted {
+			value, err := appendQuoted(nil, v.String(), &t.escape)
+			if err != nil {
+				return err
+			}
+			return t.WriteStringValue(string(value))
+		}
+		return t.WriteStringValue(v.String())
+	case reflect.Interface:
+		return t.writeReflectValue(v.Elem(), quoted)
+	case reflect.Ptr:
+		leave, err := t.enterReference(v, 0)
+		if err != nil {
+			return err
+		}
+		defer leave()
+		return t.writeReflectValue(v.Elem(), quoted)
+	case reflect.Struct:
+		return t.writeStruct(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return t.WriteNullValue()
+		}
+		leave, err := t.enterReference(v, 0)
+		if err != nil {
+			return err
+		}
+		defer leave()
+		return t.writeMap(v)
+	case reflect.Slice:
+		if v.IsNil() {
+			return t.WriteNullValue()
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 && !reflect.PtrTo(v.Type().Elem()).Implements(jsonMarshalerType) && !reflect.PtrTo(v.Type().Elem()).Implements(textMarshalerType) {
+			return t.WriteStringValue(base64.StdEncoding.EncodeToString(v.Bytes()))
+		}
+		leave, err := t.enterReference(v, v.Len())
+		if err != nil {
+			return err
+		}
+		defer leave()
+		return t.writeArray(v)
+	case reflect.Array:
+		return t.writeArray(v)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+}
+
+// reference identifies a pointer, map or slice being written, slices sharing an array differ in length.
+type reference struct {
+	ptr    uintptr
+	typ    reflect.Type
+	length int
+}
+
+// enterReference tracks the pointers, maps and slices being written to detect cycles, which would
+// recurse without end. Unlike encoding/json, tracking starts at the first level so that a cycle
+// fails before any of it has been streamed. The returned function must be called when v has been written.
+func (t *TokenWriter) enterReference(v reflect.Value, length int) (func(), error) {
+	ref := reference{ptr: v.Pointer(), typ: v.Type(), length: length}
+	if _, seen := t.references[ref]; seen {
+		return nil, fmt.Errorf("encountered a cycle via %s", v.Type())
+	}
+	if t.references == nil {
+		t.references = map[reference]struct{}{}
+	}
+	t.references[ref] = struct{}{}
+
+	return func() {
+		delete(t.references, ref)
+	}, nil
+}
+
+// writeTime writes tm in RFC 3339 format, failing like encoding/json for years it cannot represent.
+func (t *TokenWriter) writeTime(tm time.Time) error {
+	if y := tm.Year(); y < 0 || y > 9999 {
+		return fmt.Errorf("Time.MarshalJSON: year outside of range [0,9999]")
+	}
+	return t.WriteStringValue(tm.Format(time.RFC3339Nano))
+}
+
+// writeSpecialValue writes types with dedicated support and marshalers, it returns false if v is none of them.
+func (t *TokenWriter) writeSpecialValue(v reflect.Value) (bool, error) {
+	typ := v.Type()
+	switch {
+	case typ == bigIntType || typ == reflect.PtrTo(bigIntType):
+		return true, t.WriteBigIntValue(addressOf(v).Interface().(*big.Int))
+	case typ == bigFloatType || typ == reflect.PtrTo(bigFloatType):
+		return true, t.WriteBigFloatValue(addressOf(v).Interface().(*big.Float))
+	case typ == timeType:
+		return true, t.writeTime(v.Interface().(time.Time))
+	case typ == jsonNumberType:
+		return true, t.writeJsonNumber(v.String())
+	}
+
+	if typ.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(typ).Implements(jsonMarshalerType) {
+		v = v.Addr()
+		typ = v.Type()
+	} else if typ.Kind() != reflect.Ptr && v.CanAddr() && !typ.Implements(textMarshalerType) && reflect.PtrTo(typ).Implements(textMarshalerType) {
+		v = v.Addr()
+		typ = v.Type()
+	}
+
+	if typ.Implements(jsonMarshalerType) {
+		value, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return true, err
+		}
+		return true, t.WriteRawValue(value)
+	}
+
+	if typ.Implements(textMarshalerType) {
+		value, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return true, err
+		}
+		return true, t.WriteStringValue(string(value))
+	}
+
+	return false, nil
+}
+
+// addressOf returns a pointer to v, copying v if it is not addressable.
+func addressOf(v reflect.Value) reflect.Value {
+	if v.Kind() == reflect.Ptr {
+		return v
+	}
+	if v.CanAddr() {
+		return v.Addr()
+	}
+
+	ptr := reflect.New(v.Type())
+	ptr.Elem().Set(v)
+	return ptr
+}
+
+func (t *TokenWriter) writeJsonNumber(value string) error {
+	if value == "" {
+		value = "0"
+	}
+
+	tokens, err := parseRawValue([]byte(value))
+	if err != nil || len(tokens) != 1 || (tokens[0].Type != TT_NUMBER_VALUE && tokens[0].Type != TT_INTEGER_VALUE) {
+		return fmt.Errorf("invalid number literal %q", value)
+	}
+
+	return t.WriteToken(tokens[0])
+}
+
+func (t *TokenWriter) writeStruct(v reflect.Value) error {
+	err := t.WriteObjectStart()
+	if err != nil {
+		return err
+	}
+
+	for _, field := range structFields(v.Type()) {
+		fieldValue, found := fieldByIndex(v, field.index)
+		if !found || (field.omitEmpty && isEmptyValue(fieldValue)) {
+			continue
+		}
+
+		err := t.WriteKey(field.name)
+		if err != nil {
+			return err
+		}
+
+		err = t.writeReflectValue(fieldValue, field.quoted)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.WriteObjectEnd()
+}
+
+func (t *TokenWriter) writeMap(v reflect.Value) error {
+	type mapEntry struct {
+		key   string
+		value reflect.Value
+	}
+
+	entries := make([]mapEntry, 0, v.Len())
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := mapKeyString(iter.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, mapEntry{key: key, value: iter.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	err := t.WriteObjectStart()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		err := t.WriteKey(entry.key)
+		if err != nil {
+			return err
+		}
+
+		err = t.writeReflectValue(entry.value, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.WriteObjectEnd()
+}
+
+func mapKeyString(key reflect.Value) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+
+	if key.Type().Implements(textMarshalerType) {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", nil
+		}
+		text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(text), err
+	}
+
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	default:
+		return "", fmt.Errorf("unsupported map key type %s", key.Type())
+	}
+}
+
+func (t *TokenWriter) writeArray(v reflect.Value) error {
+	err := t.WriteArrayStart()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		err := t.writeReflectValue(v.Index(i), false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return t.WriteArrayEnd()
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+func testEncodes(t *testing.T, v interface{}, expectedJson string) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	err := wr.WriteValue(v)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	assert.Equal(t, expectedJson, buf.String())
+}
+
+type Base struct {
+	ID      int64  `json:"id"`
+	Comment string `json:"comment,omitempty"`
+}
+
+type Item struct {
+	Base
+	Name     string            `json:"name"`
+	Count    int               `json:"count,string"`
+	Price    float32           `json:"price"`
+	Tags     []string          `json:"tags,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Parent   *Item             `json:"parent"`
+	Ignored  string            `json:"-"`
+	Untagged bool
+	internal string
+}
+
+type upperCase string
+
+func (u upperCase) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("{\"upper\": %q}", string(u))), nil
+}
+
+type failingMarshaler struct{}
+
+func (f failingMarshaler) MarshalJSON() ([]byte, error) {
+	return []byte("{"), nil
+}
+
+func TestEncodesScalars(t *testing.T) {
+	testEncodes(t, nil, "null")
+	testEncodes(t, true, "true")
+	testEncodes(t, -42, "-42")
+	testEncodes(t, uint8(7), "7")
+	testEncodes(t, 1.5, "1.5")
+	testEncodes(t, float32(0.1), "0.1")
+	testEncodes(t, "a\"b", "\"a\\\"b\"")
+	testEncodes(t, []byte("hello"), "\"aGVsbG8=\"")
+	testEncodes(t, json.Number("1.50"), "1.50")
+}
+
+func TestEncodesStructWithTags(t *testing.T) {
+	item := Item{Base: Base{ID: 1}, Name: "child", Count: 3, Price: 9.99, Parent: &Item{Base: Base{ID: 2, Comment: "root"}, Name: "parent", Tags: []string{"a", "b"}}, Ignored: "x", Untagged: true, internal: "y"}
+	testEncodes(t, item, "{\"id\":1,\"name\":\"child\",\"count\":\"3\",\"price\":9.99,\"parent\":{\"id\":2,\"comment\":\"root\",\"name\":\"parent\",\"count\":\"0\",\"price\":0,\"tags\":[\"a\",\"b\"],\"parent\":null,\"Untagged\":false},\"Untagged\":true}")
+}
+
+func TestEncodesMapsWithSortedKeys(t *testing.T) {
+	testEncodes(t, map[string]interface{}{"b": 1, "a": []interface{}{nil, "x"}, "c": map[int]bool{10: true, 2: false}}, "{\"a\":[null,\"x\"],\"b\":1,\"c\":{\"10\":true,\"2\":false}}")
+	testEncodes(t, map[string]int(nil), "null")
+}
+
+func TestEncodesSpecialTypes(t *testing.T) {
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	when := time.Date(2021, 3, 4, 5, 6, 7, 800, time.UTC)
+	testEncodes(t, []interface{}{bigInt, *big.NewFloat(2.5), when, &when, net.ParseIP("127.0.0.1"), upperCase("x"), [2]int{1, 2}},
+		"[123456789012345678901234567890,2.5,\"2021-03-04T05:06:07.0000008Z\",\"2021-03-04T05:06:07.0000008Z\",\"127.0.0.1\",{\"upper\":\"x\"},[1,2]]")
+}
+
+func TestFailsOnTimeOutsideYearRange(t *testing.T) {
+	for _, year := range []int{-1, 10000} {
+		buf := bytes.Buffer{}
+		wr := NewTokenWriter(&buf)
+
+		err := wr.WriteValue(time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC))
+		assert.EqualError(t, err, "Time.MarshalJSON: year outside of range [0,9999]")
+		assert.Equal(t, "", buf.String())
+	}
+}
+
+func TestEncodesMarshalerOutputValidated(t *testing.T) {
+	wr := NewTokenWriter(new(bytes.Buffer))
+
+	err := wr.WriteValue(failingMarshaler{})
+	assert.EqualError(t, err, "invalid raw value: unexpected end of input in TRS_IN_OBJECT at line 1, column 2 (offset 1, path $)")
+}
+
+func TestFailsOnUnsupportedType(t *testing.T) {
+	wr := NewTokenWriter(new(bytes.Buffer))
+
+	err := wr.WriteValue(make(chan int))
+	assert.EqualError(t, err, "unsupported type chan int")
+}
+
+func TestEncodesLikeEncodingJson(t *testing.T) {
+	item := Item{Base: Base{ID: 7, Comment: "c"}, Name: "<n>", Count: 1, Labels: map[string]string{"z": "1", "y": "2"}}
+	expected, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetEscapeHTML(true)
+	assert.NoError(t, wr.WriteValue(item))
+	assert.Equal(t, string(expected), buf.String())
+}
+
+func TestEncodesValuesWithinHandWrittenEnvelope(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndStringValue("type", "items"))
+	assert.NoError(t, wr.WriteKey("items"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteValue(Base{ID: 1}))
+	assert.NoError(t, wr.WriteValue(&Base{ID: 2}))
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.WriteKeyAndValue("count", 2))
+	assert.NoError(t, wr.WriteObjectEnd())
+
+	assert.Equal(t, "{\"type\":\"items\",\"items\":[{\"id\":1},{\"id\":2}],\"count\":2}", buf.String())
+}
+
+type node struct {
+	Next *node `json:"next"`
+}
+
+func TestFailsOnPointerCycle(t *testing.T) {
+	n := &node{}
+	n.Next = n
+	wr := NewTokenWriter(ioutil.Discard)
+
+	err := wr.WriteValue(n)
+	assert.EqualError(t, err, "encountered a cycle via *internal.node")
+}
+
+func TestFailsOnCycleBeforeStreamingIt(t *testing.T) {
+	n := &node{}
+	n.Next = n
+	buf := bytes.Buffer{}
+	wr := NewTokenWriter(&buf)
+
+	err := wr.WriteValue(n)
+	assert.EqualError(t, err, "encountered a cycle via *internal.node")
+	_ = wr.Flush()
+	assert.Equal(t, "{\"next\"", buf.String())
+}
+
+func TestFailsOnMapAndSliceCycles(t *testing.T) {
+	m := map[string]interface{}{}
+	m["m"] = m
+	s := []interface{}{nil}
+	s[0] = s
+
+	assert.EqualError(t, NewTokenWriter(ioutil.Discard).WriteValue(m), "encountered a cycle via map[string]interface {}")
+	assert.EqualError(t, NewTokenWriter(ioutil.Discard).WriteValue(s), "encountered a cycle via []interface {}")
+}
+
+func TestEncodesDeepAcyclicValues(t *testing.T) {
+	var n *node
+	for i := 0; i < 2000; i++ {
+		n = &node{Next: n}
+	}
+	shared := &Base{ID: 1}
+
+	assert.NoError(t, NewTokenWriter(ioutil.Discard).WriteValue(n))
+	testEncodes(t, []*Base{shared, shared}, "[{\"id\":1},{\"id\":1}]")
+}
+
+type quotedFloat struct {
+	F float64 `json:"f,string"`
+}
+
+func TestAppliesNonFinitePolicyToQuotedFloats(t *testing.T) {
+	wr := NewTokenWriter(new(bytes.Buffer))
+	err := wr.WriteValue(quotedFloat{F: math.NaN()})
+	assert.EqualError(t, err, "unsupported number value NaN")
+
+	buf := new(bytes.Buffer)
+	wr = NewTokenWriter(buf)
+	wr.SetNonFiniteNumberPolicy(NFP_STRING)
+	assert.NoError(t, wr.WriteValue([]quotedFloat{{F: math.Inf(1)}, {F: 1.5}}))
+	assert.Equal(t, "[{\"f\":\"Infinity\"},{\"f\":\"1.5\"}]", buf.String())
+}
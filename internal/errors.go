@@ -0,0 +1,33 @@
+package internal
+
+import (
+	"fmt"
+)
+
+// SyntaxError describes malformed input found by TokenReader. Offset is the byte offset
+// of the offending input, Line and Column are 1-based with Column counting bytes.
+type SyntaxError struct {
+	Msg    string
+	Offset int64
+	Line   int
+	Column int
+	Path   string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("%s at line %d, column %d (offset %d, path %s)", e.Msg, e.Line, e.Column, e.Offset, e.Path)
+}
+
+// DuplicateKeyError is returned for a key written or read twice in the same object
+// if duplicate keys are rejected.
+type DuplicateKeyError struct {
+	Key  string
+	Path string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("duplicate key %q", e.Key)
+	}
+	return fmt.Sprintf("duplicate key %q at %s", e.Key, e.Path)
+}
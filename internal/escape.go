@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type InvalidUTF8Policy int
+
+const (
+	IUP_REPLACE InvalidUTF8Policy = iota
+	IUP_ERROR
+)
+
+var invalidUTF8PolicyNames = []string{"IUP_REPLACE", "IUP_ERROR"}
+
+func (p InvalidUTF8Policy) Name() string {
+	return invalidUTF8PolicyNames[p]
+}
+
+type escapeOptions struct {
+	escapeHTML            bool
+	escapeLineTerminators bool
+	asciiOnly             bool
+	invalidUTF8Policy     InvalidUTF8Policy
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendQuoted appends s as quoted json string to dst, escaping quotes, backslashes
+// and control characters as required by RFC 8259 plus whatever opts asks for.
+func appendQuoted(dst []byte, s string, opts *escapeOptions) ([]byte, error) {
+	dst = append(dst, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && !(opts.escapeHTML && (b == '<' || b == '>' || b == '&')) {
+				i++
+				continue
+			}
+
+			dst = append(dst, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				dst = append(dst, '\\', b)
+			case '\b':
+				dst = append(dst, '\\', 'b')
+			case '\f':
+				dst = append(dst, '\\', 'f')
+			case '\n':
+				dst = append(dst, '\\', 'n')
+			case '\r':
+				dst = append(dst, '\\', 'r')
+			case '\t':
+				dst = append(dst, '\\', 't')
+			default:
+				dst = appendUnicodeEscape(dst, rune(b))
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			if opts.invalidUTF8Policy == IUP_ERROR {
+				return dst, fmt.Errorf("invalid UTF-8 at byte offset %d", i)
+			}
+
+			dst = append(dst, s[start:i]...)
+			if opts.asciiOnly {
+				dst = appendUnicodeEscape(dst, utf8.RuneError)
+			} else {
+				dst = append(dst, "\ufffd"...)
+			}
+			i += size
+			start = i
+			continue
+		}
+
+		if opts.asciiOnly || (opts.escapeLineTerminators && (r == '\u2028' || r == '\u2029')) {
+			dst = append(dst, s[start:i]...)
+			dst = appendUnicodeEscape(dst, r)
+			i += size
+			start = i
+			continue
+		}
+
+		i += size
+	}
+	dst = append(dst, s[start:]...)
+	dst = append(dst, '"')
+
+	return dst, nil
+}
+
+// appendUnicodeEscape appends r as \uXXXX escape, using a surrogate pair for runes outside the BMP.
+func appendUnicodeEscape(dst []byte, r rune) []byte {
+	if r > 0xffff {
+		r1, r2 := utf16.EncodeRune(r)
+		dst = appendUnicodeEscape(dst, r1)
+		return appendUnicodeEscape(dst, r2)
+	}
+
+	return append(dst, '\\', 'u', hexDigits[r>>12&0xf], hexDigits[r>>8&0xf], hexDigits[r>>4&0xf], hexDigits[r&0xf])
+}
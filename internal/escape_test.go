@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func testQuotes(t *testing.T, opts escapeOptions, value string, expected string) {
+	quoted, err := appendQuoted(nil, value, &opts)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	assert.Equal(t, expected, string(quoted))
+}
+
+func TestQuotesPlainString(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "value", "\"value\"")
+}
+
+func TestEscapesQuoteAndBackslash(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "a\"b\\c", "\"a\\\"b\\\\c\"")
+}
+
+func TestEscapesControlCharacters(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "\b\f\n\r\t\x00\x1f", "\"\\b\\f\\n\\r\\t\\u0000\\u001f\"")
+}
+
+func TestKeepsHTMLCharactersByDefault(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "<a&b>", "\"<a&b>\"")
+}
+
+func TestEscapesHTMLCharacters(t *testing.T) {
+	testQuotes(t, escapeOptions{escapeHTML: true}, "<a&b>", "\"\\u003ca\\u0026b\\u003e\"")
+}
+
+func TestEscapesLineTerminators(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "a\u2028b\u2029", "\"a\u2028b\u2029\"")
+	testQuotes(t, escapeOptions{escapeLineTerminators: true}, "a\u2028b\u2029", "\"a\\u2028b\\u2029\"")
+}
+
+func TestEscapesNonASCIIWithSurrogatePairs(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "ä€😀", "\"ä€😀\"")
+	testQuotes(t, escapeOptions{asciiOnly: true}, "ä€😀", "\"\\u00e4\\u20ac\\ud83d\\ude00\"")
+}
+
+func TestReplacesInvalidUTF8(t *testing.T) {
+	testQuotes(t, escapeOptions{}, "a\xffb", "\"a�b\"")
+	testQuotes(t, escapeOptions{asciiOnly: true}, "a\xffb", "\"a\\ufffdb\"")
+}
+
+func TestFailsOnInvalidUTF8(t *testing.T) {
+	_, err := appendQuoted(nil, "ab\xc3", &escapeOptions{invalidUTF8Policy: IUP_ERROR})
+	assert.EqualError(t, err, "invalid UTF-8 at byte offset 2")
+}
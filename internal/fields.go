@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// structField describes a json property of a struct, following the rules of encoding/json
+// for `json` tags and embedded structs.
+type structField struct {
+	name      string
+	index     []int
+	tagged    bool
+	omitEmpty bool
+	quoted    bool
+}
+
+var structFieldCache sync.Map
+
+// structFields returns the json properties of struct type typ in field order.
+func structFields(typ reflect.Type) []structField {
+	if cached, found := structFieldCache.Load(typ); found {
+		return cached.([]structField)
+	}
+
+	fields, _ := structFieldCache.LoadOrStore(typ, collectStructFields(typ))
+	return fields.([]structField)
+}
+
+func collectStructFields(typ reflect.Type) []structField {
+	candidates := []structField{}
+	collectStructFieldCandidates(typ, nil, map[reflect.Type]bool{}, &candidates)
+
+	// fields with the same name are resolved like in encoding/json: the shallowest
+	// wins, on equal depth a tagged field wins, otherwise all of them are dropped.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].name != candidates[j].name {
+			return candidates[i].name < candidates[j].name
+		}
+		if len(candidates[i].index) != len(candidates[j].index) {
+			return len(candidates[i].index) < len(candidates[j].index)
+		}
+		return candidates[i].tagged && !candidates[j].tagged
+	})
+
+	fields := []structField{}
+	for i := 0; i < len(candidates); {
+		j := i + 1
+		for j < len(candidates) && candidates[j].name == candidates[i].name {
+			j++
+		}
+
+		dominant := candidates[i]
+		if j-i == 1 || len(candidates[i+1].index) > len(dominant.index) || (dominant.tagged && !candidates[i+1].tagged) {
+			fields = append(fields, dominant)
+		}
+		i = j
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return lessIndex(fields[i].index, fields[j].index)
+	})
+
+	return fields
+}
+
+func collectStructFieldCandidates(typ reflect.Type, parentIndex []int, visited map[reflect.Type]bool, candidates *[]structField) {
+	if visited[typ] {
+		return
+	}
+	visited[typ] = true
+	defer delete(visited, typ)
+
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		if field.PkgPath != "" && !(field.Anonymous && fieldType.Kind() == reflect.Struct) {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options := parseTag(tag)
+		index := append(append([]int{}, parentIndex...), i)
+		if name == "" && field.Anonymous && fieldType.Kind() == reflect.Struct {
+			collectStructFieldCandidates(fieldType, index, visited, candidates)
+			continue
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		tagged := name != ""
+		if !tagged {
+			name = field.Name
+		}
+
+		quoted := false
+		if hasOption(options, "string") {
+			switch fieldType.Kind() {
+			case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+				quoted = true
+			}
+		}
+
+		*candidates = append(*candidates, structField{name: name, index: index, tagged: tagged, omitEmpty: hasOption(options, "omitempty"), quoted: quoted})
+	}
+}
+
+func parseTag(tag string) (string, string) {
+	if i := strings.Index(tag, ","); i != -1 {
+		return tag[:i], tag[i+1:]
+	}
+	return tag, ""
+}
+
+func hasOption(options string, option string) bool {
+	for options != "" {
+		var current string
+		if i := strings.Index(options, ","); i != -1 {
+			current, options = options[:i], options[i+1:]
+		} else {
+			current, options = options, ""
+		}
+
+		if current == option {
+			return true
+		}
+	}
+
+	return false
+}
+
+func lessIndex(a []int, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// fieldByIndex returns the field of struct value v, or false if an embedded pointer on the way is nil.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+
+	return v, true
+}
@@ -0,0 +1,370 @@
+package internal
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TokenFilter transforms the tokens written to a TokenWriter before they reach its state machine.
+// FilterToken is called for every token except colons and commas, which the TokenWriter adds
+// itself, and passes the tokens to write on to emit, any number of them. The filters of this
+// package get fresh state for every writer and top-level value, custom filters keeping state
+// must not be shared between writers.
+type TokenFilter interface {
+	FilterToken(token Token, path *TokenPath, emit func(token Token) error) error
+}
+
+// statefulFilter is implemented by filters keeping state while filtering a value.
+type statefulFilter interface {
+	// newInstance returns a copy of the filter with fresh state
+	newInstance() TokenFilter
+}
+
+// filterInstance returns a new instance of filter if it keeps state, filter itself otherwise.
+func filterInstance(filter TokenFilter) TokenFilter {
+	if stateful, isStateful := filter.(statefulFilter); isStateful {
+		return stateful.newInstance()
+	}
+
+	return filter
+}
+
+// TokenFilterFunc adapts a function to a TokenFilter.
+type TokenFilterFunc func(token Token, path *TokenPath, emit func(token Token) error) error
+
+func (f TokenFilterFunc) FilterToken(token Token, path *TokenPath, emit func(token Token) error) error {
+	return f(token, path, emit)
+}
+
+// TokenPath is the structural position of a token passed to a TokenFilter, like $.items[42].name.
+// Keys have the path of their value, start and end tokens the path of their container.
+type TokenPath struct {
+	segments pathStack
+}
+
+func (p *TokenPath) String() string {
+	return p.segments.String()
+}
+
+// Depth returns the number of containers around the token.
+func (p *TokenPath) Depth() int {
+	return len(p.segments)
+}
+
+// Key returns the key of the innermost object member, false if the token is not inside an object member.
+func (p *TokenPath) Key() (string, bool) {
+	if len(p.segments) == 0 {
+		return "", false
+	}
+
+	segment := p.segments[len(p.segments)-1]
+	return segment.key, segment.hasKey
+}
+
+// Matches reports if the path matches pattern, a path like $.items[42].name in which .* matches
+// any key and [*] any index. Keys that are not identifiers are written quoted like $["a.b"].
+func (p *TokenPath) Matches(pattern string) bool {
+	if !strings.HasPrefix(pattern, "$") {
+		return false
+	}
+
+	rest := pattern[1:]
+	for _, segment := range p.segments {
+		var matches bool
+		matches, rest = matchPathSegment(segment, rest)
+		if !matches {
+			return false
+		}
+	}
+
+	return rest == ""
+}
+
+// matchPathSegment matches segment against the start of pattern and returns the rest of pattern.
+func matchPathSegment(segment pathSegment, pattern string) (bool, string) {
+	if strings.HasPrefix(pattern, ".") {
+		end := strings.IndexAny(pattern[1:], ".[")
+		if end == -1 {
+			end = len(pattern) - 1
+		}
+		key := pattern[1 : end+1]
+		return !segment.isIndex && segment.hasKey && (key == "*" || key == segment.key), pattern[end+1:]
+	}
+
+	if strings.HasPrefix(pattern, "[\"") {
+		end := 2
+		for end < len(pattern) && pattern[end] != '"' {
+			if pattern[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(pattern) {
+			return false, ""
+		}
+		key, err := strconv.Unquote(pattern[1 : end+1])
+		if err != nil || !strings.HasPrefix(pattern[end+1:], "]") {
+			return false, ""
+		}
+		return !segment.isIndex && segment.hasKey && key == segment.key, pattern[end+2:]
+	}
+
+	if strings.HasPrefix(pattern, "[") {
+		end := strings.IndexByte(pattern, ']')
+		if end == -1 {
+			return false, ""
+		}
+		index := pattern[1:end]
+		return segment.isIndex && (index == "*" || index == strconv.Itoa(segment.index)), pattern[end+1:]
+	}
+
+	return false, ""
+}
+
+// filterStage runs an instance of a filter and tracks the path of the tokens passed to it.
+// Every top-level value is filtered by a new instance.
+type filterStage struct {
+	filter   TokenFilter
+	instance TokenFilter
+	path     TokenPath
+	next     func(token Token) error
+}
+
+func newFilterStage(filter TokenFilter, next func(token Token) error) *filterStage {
+	return &filterStage{filter: filter, instance: filterInstance(filter), next: next}
+}
+
+func (s *filterStage) writeToken(token Token) error {
+	if len(s.path.segments) == 0 && token.Type != TT_DOCUMENT_END && token.Type != TT_COLON && token.Type != TT_COMMA {
+		s.reset()
+	}
+
+	return s.track(token, func() error {
+		return s.instance.FilterToken(token, &s.path, s.next)
+	})
+}
+
+// reset drops the state left over from a value that has not been filtered completely.
+func (s *filterStage) reset() {
+	s.path.segments = s.path.segments[:0]
+	s.instance = filterInstance(s.filter)
+}
+
+// bypass passes token on without filtering it, its path is still tracked.
+func (s *filterStage) bypass(token Token) error {
+	return s.track(token, func() error {
+		return s.next(token)
+	})
+}
+
+// track advances the path to token around write.
+func (s *filterStage) track(token Token, write func() error) error {
+	segments := &s.path.segments
+	switch token.Type {
+	case TT_COLON, TT_COMMA:
+		// separators are added by the TokenWriter, after filtering they could be misplaced
+		return nil
+	case TT_KEY:
+		if len(*segments) > 0 && !(*segments)[len(*segments)-1].isIndex {
+			segments.SetKey(token.Value)
+		}
+	case TT_OBJECT_END, TT_ARRAY_END:
+		if len(*segments) > 0 {
+			segments.Pop()
+		}
+	case TT_DOCUMENT_END:
+	default:
+		segments.NextValue()
+	}
+
+	err := write()
+
+	switch token.Type {
+	case TT_OBJECT_START:
+		segments.PushObject()
+	case TT_ARRAY_START:
+		segments.PushArray()
+	}
+
+	return err
+}
+
+// FilterChain is a TokenFilter passing the tokens through a sequence of filters, each one
+// seeing the tokens and paths produced by the ones before. A chain keeps state, a writer
+// filters with a copy of it.
+type FilterChain struct {
+	filters []TokenFilter
+	stages  []*filterStage
+	emit    func(token Token) error
+}
+
+func NewFilterChain(filters ...TokenFilter) *FilterChain {
+	return &FilterChain{filters: filters}
+}
+
+// Then appends filter to the chain.
+func (c *FilterChain) Then(filter TokenFilter) *FilterChain {
+	c.filters = append(c.filters, filter)
+	c.stages = nil
+	return c
+}
+
+func (c *FilterChain) FilterToken(token Token, path *TokenPath, emit func(token Token) error) error {
+	if len(c.filters) == 0 {
+		return emit(token)
+	}
+
+	if c.stages == nil {
+		next := func(token Token) error {
+			return c.emit(token)
+		}
+		for i := len(c.filters) - 1; i >= 0; i-- {
+			stage := newFilterStage(c.filters[i], next)
+			c.stages = append([]*filterStage{stage}, c.stages...)
+			next = stage.writeToken
+		}
+	}
+
+	c.emit = emit
+	return c.stages[0].writeToken(token)
+}
+
+func (c *FilterChain) newInstance() TokenFilter {
+	return NewFilterChain(c.filters...)
+}
+
+// subtreeFilter replaces the values at paths matching one of its patterns. Keys and values of
+// object members are passed to replace together, the tokens of a replaced container are dropped.
+type subtreeFilter struct {
+	patterns []string
+	// replace emits the replacement for the key or value token at a matching path,
+	// nil drops the member or item
+	replace func(token Token, emit func(token Token) error) error
+	// depth counts the open containers of the value being dropped
+	depth    int
+	dropping bool
+}
+
+func (f *subtreeFilter) FilterToken(token Token, path *TokenPath, emit func(token Token) error) error {
+	if f.dropping {
+		switch token.Type {
+		case TT_OBJECT_START, TT_ARRAY_START:
+			f.depth++
+		case TT_OBJECT_END, TT_ARRAY_END:
+			f.depth--
+		}
+		f.dropping = f.depth > 0
+		return nil
+	}
+
+	if token.Type == TT_OBJECT_END || token.Type == TT_ARRAY_END || token.Type == TT_DOCUMENT_END || !f.matches(path) {
+		return emit(token)
+	}
+
+	if token.Type == TT_OBJECT_START || token.Type == TT_ARRAY_START {
+		f.dropping = true
+		f.depth = 1
+	}
+
+	if f.replace == nil {
+		if token.Type == TT_KEY {
+			// the value follows at the same path
+			f.dropping = true
+			f.depth = 0
+		}
+		return nil
+	}
+	return f.replace(token, emit)
+}
+
+func (f *subtreeFilter) newInstance() TokenFilter {
+	return &subtreeFilter{patterns: f.patterns, replace: f.replace}
+}
+
+func (f *subtreeFilter) matches(path *TokenPath) bool {
+	for _, pattern := range f.patterns {
+		if path.Matches(pattern) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// DropFilter drops object members and array items at paths matching one of patterns.
+func DropFilter(patterns ...string) TokenFilter {
+	return &subtreeFilter{patterns: patterns}
+}
+
+// MaskFilter replaces values at paths matching one of patterns by the string mask, containers included.
+func MaskFilter(mask string, patterns ...string) TokenFilter {
+	return &subtreeFilter{patterns: patterns, replace: func(token Token, emit func(token Token) error) error {
+		if token.Type == TT_KEY {
+			return emit(token)
+		}
+		return emit(Token{Type: TT_STRING_VALUE, Value: mask})
+	}}
+}
+
+// ConvertFilter replaces scalar values at paths matching one of patterns by the result of convert,
+// like numbers by strings.
+func ConvertFilter(convert func(token Token) (Token, error), patterns ...string) TokenFilter {
+	return TokenFilterFunc(func(token Token, path *TokenPath, emit func(token Token) error) error {
+		switch token.Type {
+		case TT_STRING_VALUE, TT_NULL_VALUE, TT_TRUE_VALUE, TT_FALSE_VALUE, TT_NUMBER_VALUE, TT_INTEGER_VALUE, TT_RAW_VALUE:
+		default:
+			return emit(token)
+		}
+
+		for _, pattern := range patterns {
+			if path.Matches(pattern) {
+				converted, err := convert(token)
+				if err != nil {
+					return err
+				}
+				return emit(converted)
+			}
+		}
+
+		return emit(token)
+	})
+}
+
+// RenameFilter renames keys at paths matching the patterns of renames to the new names they map to.
+// If several patterns match, the one with the fewest wildcards wins, ties are decided by the
+// lexical order of the patterns.
+func RenameFilter(renames map[string]string) TokenFilter {
+	patterns := make([]string, 0, len(renames))
+	for pattern := range renames {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		wildcardsI, wildcardsJ := strings.Count(patterns[i], "*"), strings.Count(patterns[j], "*")
+		if wildcardsI != wildcardsJ {
+			return wildcardsI < wildcardsJ
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	return TokenFilterFunc(func(token Token, path *TokenPath, emit func(token Token) error) error {
+		if token.Type == TT_KEY {
+			for _, pattern := range patterns {
+				if path.Matches(pattern) {
+					return emit(Token{Type: TT_KEY, Value: renames[pattern]})
+				}
+			}
+		}
+
+		return emit(token)
+	})
+}
+
+// NumberToString converts number values to strings, for use with ConvertFilter.
+func NumberToString(token Token) (Token, error) {
+	if token.Type == TT_NUMBER_VALUE || token.Type == TT_INTEGER_VALUE {
+		return Token{Type: TT_STRING_VALUE, Value: token.Value}, nil
+	}
+
+	return token, nil
+}
@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func writeFiltered(t *testing.T, filter TokenFilter, json string) (string, error) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetTokenFilter(filter)
+
+	err := CopyTokens(wr, NewTokenReader(strings.NewReader(json)))
+	if err != nil {
+		return buf.String(), err
+	}
+
+	return buf.String(), wr.Close()
+}
+
+func TestDropsMatchingMembersAndItems(t *testing.T) {
+	out, err := writeFiltered(t, DropFilter("$.password", "$.items[*].secret", "$.items[1]"),
+		`{"user":"a","password":{"x":[1]},"items":[{"id":1,"secret":2},{"id":2},{"id":3,"secret":[3]}]}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"user":"a","items":[{"id":1},{"id":3}]}`, out)
+}
+
+func TestRenamesKeys(t *testing.T) {
+	out, err := writeFiltered(t, RenameFilter(map[string]string{"$.a.*": "renamed", `$["x.y"]`: "xy"}),
+		`{"a":{"b":1},"b":2,"x.y":3}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":{"renamed":1},"b":2,"xy":3}`, out)
+}
+
+func TestRenamesKeysByMostSpecificPattern(t *testing.T) {
+	renames := map[string]string{"$.*": "any", "$.a": "exact", "$.*.*": "nested", "$.b.*": "inB", "$.*.c": "anyC"}
+	for i := 0; i < 20; i++ {
+		out, err := writeFiltered(t, RenameFilter(renames), `{"a":1,"b":{"c":2},"d":{"c":3}}`)
+
+		assert.NoError(t, err)
+		assert.Equal(t, `{"exact":1,"any":{"anyC":2},"any":{"anyC":3}}`, out)
+	}
+}
+
+func TestMasksValues(t *testing.T) {
+	out, err := writeFiltered(t, MaskFilter("***", "$.card", "$.tokens[*]"),
+		`{"card":{"number":"4111"},"tokens":["a",1],"name":"n"}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"card":"***","tokens":["***","***"],"name":"n"}`, out)
+}
+
+func TestConvertsValues(t *testing.T) {
+	out, err := writeFiltered(t, ConvertFilter(NumberToString, "$.ids[*]"), `{"ids":[12345678901234567890,2.5],"n":1}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"ids":["12345678901234567890","2.5"],"n":1}`, out)
+}
+
+func TestChainsFiltersOnProducedPaths(t *testing.T) {
+	chain := NewFilterChain(RenameFilter(map[string]string{"$.old": "new"})).
+		Then(MaskFilter("-", "$.new"))
+
+	out, err := writeFiltered(t, chain, `{"old":"secret","other":"x"}`)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"new":"-","other":"x"}`, out)
+}
+
+func TestPassesFilterErrors(t *testing.T) {
+	failure := errors.New("failure")
+	filter := TokenFilterFunc(func(token Token, path *TokenPath, emit func(token Token) error) error {
+		if path.String() == "$[1]" {
+			return failure
+		}
+		return emit(token)
+	})
+
+	_, err := writeFiltered(t, filter, `[1,2]`)
+
+	assert.Equal(t, failure, err)
+}
+
+func TestFiltersWriteCalls(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetTokenFilter(DropFilter("$.b"))
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKeyAndValue("b", []int{2}))
+	assert.NoError(t, wr.WriteKeyAndRawValue("c", []byte(`{"b":3}`)))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, `{"a":1,"c":{"b":3}}`, buf.String())
+}
+
+func TestMatchesPaths(t *testing.T) {
+	path := &TokenPath{}
+	path.segments.PushObject()
+	path.segments.SetKey("items")
+	path.segments.PushArray()
+	path.segments.NextValue()
+	path.segments.PushObject()
+	path.segments.SetKey("a.b")
+
+	assert.Equal(t, `$.items[0]["a.b"]`, path.String())
+	assert.True(t, path.Matches(`$.items[0]["a.b"]`))
+	assert.True(t, path.Matches(`$.*[*].*`))
+	assert.False(t, path.Matches(`$.items[1]["a.b"]`))
+	assert.False(t, path.Matches(`$.items[0]`))
+	assert.False(t, path.Matches(`$.items[0]["a.b`))
+	assert.False(t, path.Matches(`items`))
+}
+
+func TestCompletesOutputPastFilter(t *testing.T) {
+	dropNulls := TokenFilterFunc(func(token Token, path *TokenPath, emit func(token Token) error) error {
+		if token.Type == TT_NULL_VALUE {
+			return nil
+		}
+		return emit(token)
+	})
+
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetTokenFilter(dropNulls)
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.Abort(nil))
+	assert.Equal(t, `{"a":null}`, buf.String())
+
+	buf = new(bytes.Buffer)
+	wr = NewTokenWriter(buf)
+	wr.SetTokenFilter(dropNulls)
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("b"))
+	assert.NoError(t, wr.CloseAndComplete())
+	assert.Equal(t, `[1,{"b":null}]`, buf.String())
+}
+
+func TestWritesAbortMarkerWhileDroppingSubtree(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetTokenFilter(DropFilter("$.secret"))
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("secret"))
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("x"))
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, `{"a":1,"_error":"db gone"}`, buf.String())
+}
+
+func TestSharesFilterBetweenWriters(t *testing.T) {
+	filter := NewFilterChain(DropFilter("$.secret"))
+	buf1, buf2 := new(bytes.Buffer), new(bytes.Buffer)
+	wr1, wr2 := NewTokenWriter(buf1), NewTokenWriter(buf2)
+	wr1.SetTokenFilter(filter)
+	wr2.SetTokenFilter(filter)
+
+	assert.NoError(t, wr1.WriteObjectStart())
+	assert.NoError(t, wr1.WriteKey("secret"))
+	assert.NoError(t, wr1.WriteObjectStart())
+	assert.NoError(t, wr2.WriteValue(map[string]int{"a": 1}))
+	assert.NoError(t, wr1.WriteObjectEnd())
+	assert.NoError(t, wr1.WriteKeyAndIntegerValue("b", 2))
+	assert.NoError(t, wr1.WriteObjectEnd())
+
+	assert.Equal(t, `{"b":2}`, buf1.String())
+	assert.Equal(t, `{"a":1}`, buf2.String())
+}
+
+func TestFiltersValueAfterAbortedValue(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetMultiDocument(true)
+	wr.SetTokenFilter(DropFilter("$.secret"))
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("secret"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.Abort(nil))
+	assert.NoError(t, wr.WriteValue(map[string]int{"a": 1}))
+
+	assert.Equal(t, "{}\n{\"a\":1}\n", buf.String())
+}
@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// FormatOptions control the whitespace written between tokens, the zero value writes compact json.
+type FormatOptions struct {
+	// Prefix starts every line after the first one, Indent is repeated once per nesting level, like json.Indent.
+	Prefix string
+	Indent string
+	// Multiline puts keys, items and closing brackets of non empty containers on lines of their own,
+	// implied by a non empty Prefix or Indent.
+	Multiline       bool
+	SpaceAfterColon bool
+	// SpaceAfterComma applies to containers written on a single line.
+	SpaceAfterComma bool
+	TrailingNewline bool
+	// ExpandDepth limits line breaks to containers nested at most ExpandDepth deep,
+	// deeper ones are written on a single line. 0 expands all containers.
+	ExpandDepth int
+	// InlineScalarArrayWidth keeps arrays without nested containers on a single line
+	// if they are not wider than this, 0 disables it.
+	InlineScalarArrayWidth int
+	// AlignKeys pads keys so that the scalar values of consecutive object members start in the same column.
+	AlignKeys bool
+	// MaxLineWidth packs the items of arrays without nested containers into lines of at most
+	// this width instead of putting every item on a line of its own, 0 disables it.
+	MaxLineWidth int
+}
+
+var (
+	// COMPACT_FORMAT writes json without any whitespace.
+	COMPACT_FORMAT = FormatOptions{}
+	// PRETTY_FORMAT puts every key and item on a line of its own.
+	PRETTY_FORMAT = FormatOptions{Indent: "  ", SpaceAfterColon: true}
+	// JSON_LINES_FORMAT puts every item of the top-level container on a single line of its own.
+	JSON_LINES_FORMAT = FormatOptions{Multiline: true, ExpandDepth: 1, TrailingNewline: true}
+	// READABLE_FORMAT is pretty printing with short scalar arrays kept on one line, long ones
+	// wrapped and values of objects aligned.
+	READABLE_FORMAT = FormatOptions{Indent: "  ", SpaceAfterColon: true, SpaceAfterComma: true, InlineScalarArrayWidth: 60, AlignKeys: true, MaxLineWidth: 100}
+)
+
+// MAX_HELD_LAYOUT_SIZE limits the bytes held back for aligning keys, larger objects are aligned in sections.
+const MAX_HELD_LAYOUT_SIZE = 64 * 1024
+
+// pretty reports if keys, items and closing brackets of non empty containers are put on lines of their own.
+func (f *FormatOptions) pretty() bool {
+	return f.Multiline || f.Prefix != "" || f.Indent != ""
+}
+
+// breaksLines reports if the container with items at level is written on multiple lines.
+func (f *FormatOptions) breaksLines(level int) bool {
+	return f.pretty() && (f.ExpandDepth <= 0 || level <= f.ExpandDepth)
+}
+
+// appendLineBreak appends a line break followed by the prefix and the indent for indentLevel
+// if the container with items at level is written on multiple lines.
+func (f *FormatOptions) appendLineBreak(dst []byte, level int, indentLevel int) []byte {
+	if !f.breaksLines(level) {
+		return dst
+	}
+
+	dst = append(dst, lINE_BREAK_BYTES...)
+	dst = append(dst, f.Prefix...)
+	for i := 0; i < indentLevel; i++ {
+		dst = append(dst, f.Indent...)
+	}
+
+	return dst
+}
+
+// heldContainer collects the items of a container as long as their layout depends on what follows:
+// scalar items of arrays that might be written inline or wrapped and members of objects with aligned keys.
+type heldContainer struct {
+	level   int
+	isArray bool
+	keys    [][]byte
+	values  [][]byte
+	size    int
+	// wrapping is set after the held items of an array have been written packed, further items are written immediately
+	wrapping bool
+	// written counts the items written so far
+	written int
+}
+
+// emit renders the whitespace before and after the current token into pending and writes it.
+// While the layout of the enclosing container is undecided, the token is held back instead.
+func (t *TokenWriter) emit(token Token) error {
+	t.pending = t.pending[:0]
+	tokenType := token.Type
+
+	level := len(t.stateStack) - 1
+	if t.held == nil && tokenType == TT_KEY && t.format.AlignKeys && t.format.breaksLines(level) {
+		t.held = &heldContainer{level: level}
+	}
+
+	if t.held == nil || !t.holdToken(tokenType) {
+		t.appendToken(tokenType)
+		if tokenType == TT_ARRAY_START && (t.format.InlineScalarArrayWidth > 0 || t.format.MaxLineWidth > 0) && t.format.breaksLines(level+1) {
+			t.held = &heldContainer{level: level + 1, isArray: true}
+		}
+	}
+
+	err := t.writePending()
+	if limitErr, isLimitErr := err.(*MaxTotalBytesError); isLimitErr {
+		limitErr.Path = t.tokenPath(token)
+	}
+	return err
+}
+
+// appendToken appends the current token with the surrounding whitespace to pending.
+func (t *TokenWriter) appendToken(tokenType TokenType) {
+	state := t.stateStack.Peek()
+	level := len(t.stateStack) - 1
+	if state == TWS_INITIAL && t.multiDocument {
+		t.pending = append(t.pending, t.documentPrefix...)
+	}
+	switch tokenType {
+	case TT_KEY:
+		t.pending = t.format.appendLineBreak(t.pending, level, level)
+	case TT_OBJECT_END, TT_ARRAY_END:
+		// empty containers stay on one line
+		if state != TWS_IN_OBJECT && state != TWS_IN_ARRAY {
+			t.pending = t.format.appendLineBreak(t.pending, level, level-1)
+		}
+	case TT_COLON, TT_COMMA:
+	default:
+		if state == TWS_IN_ARRAY || state == TWS_IN_ARRAY_COMMA_SEEN {
+			t.pending = t.format.appendLineBreak(t.pending, level, level)
+		}
+	}
+
+	t.pending = append(t.pending, t.text...)
+
+	switch tokenType {
+	case TT_COLON:
+		if t.format.SpaceAfterColon {
+			t.pending = append(t.pending, sPACE_BYTES...)
+		}
+	case TT_COMMA:
+		if t.format.SpaceAfterComma && !t.format.breaksLines(level) {
+			t.pending = append(t.pending, sPACE_BYTES...)
+		}
+	}
+
+	t.appendDocumentEnd(tokenType)
+}
+
+// appendDocumentEnd appends the document separator in multi document mode or the trailing
+// newline if the current token completes the top-level value.
+func (t *TokenWriter) appendDocumentEnd(tokenType TokenType) {
+	if !t.format.TrailingNewline && !t.multiDocument {
+		return
+	}
+
+	completesDocument := false
+	switch tokenType {
+	case TT_OBJECT_END, TT_ARRAY_END:
+		completesDocument = len(t.stateStack) == 2
+	case TT_STRING_VALUE, TT_NULL_VALUE, TT_TRUE_VALUE, TT_FALSE_VALUE, TT_NUMBER_VALUE, TT_INTEGER_VALUE, TT_RAW_VALUE:
+		completesDocument = t.stateStack.Peek() == TWS_INITIAL
+	}
+	if !completesDocument {
+		return
+	}
+
+	if t.multiDocument {
+		t.pending = append(t.pending, t.documentSeparator...)
+	} else {
+		t.pending = append(t.pending, lINE_BREAK_BYTES...)
+	}
+}
+
+// holdToken adds the current token to the held container or releases it. It returns false
+// if the token still has to be written the regular way.
+func (t *TokenWriter) holdToken(tokenType TokenType) bool {
+	held := t.held
+	switch tokenType {
+	case TT_COMMA, TT_COLON:
+		// separators are rendered on release
+		return true
+	case TT_KEY:
+		held.keys = append(held.keys, append([]byte{}, t.text...))
+		held.size += len(t.text)
+		return true
+	case TT_OBJECT_START, TT_ARRAY_START:
+		// a nested container breaks alignment and prevents inline arrays
+		t.releaseHeld()
+		t.held = nil
+		if held.isArray && held.written > 0 {
+			t.pending = append(t.pending, cOMMA_BYTES...)
+		}
+		return false
+	case TT_OBJECT_END, TT_ARRAY_END:
+		if held.isArray && !held.wrapping && len(held.values) > 0 && t.fitsInline() {
+			t.appendInlineArray()
+			t.appendDocumentEnd(tokenType)
+			return true
+		}
+		t.releaseHeld()
+		t.held = nil
+		return false
+	default:
+		if held.wrapping {
+			t.appendHeldItem(t.text, true)
+			return true
+		}
+
+		held.values = append(held.values, append([]byte{}, t.text...))
+		held.size += len(t.text)
+		if held.isArray && held.size > t.format.InlineScalarArrayWidth {
+			// the array cannot be written inline any more
+			t.releaseHeld()
+		} else if !held.isArray && held.size > MAX_HELD_LAYOUT_SIZE {
+			t.releaseHeld()
+		}
+		return true
+	}
+}
+
+// releaseHeld appends the held tokens to pending. Arrays that are wrapped stay held, so that
+// further items are packed into the current line.
+func (t *TokenWriter) releaseHeld() {
+	held := t.held
+	if !held.isArray {
+		t.appendAlignedMembers()
+		t.held = nil
+		return
+	}
+
+	wrap := t.format.MaxLineWidth > 0
+	for _, value := range held.values {
+		t.appendHeldItem(value, wrap)
+	}
+	held.values = nil
+	held.size = 0
+	held.wrapping = wrap
+	t.held = nil
+	if wrap {
+		t.held = held
+	}
+}
+
+// writeHeld writes the held tokens without waiting for the layout to be decided, the held array
+// is not written inline any more and the held members are aligned without the following ones.
+// If writing fails, the tokens stay held.
+func (t *TokenWriter) writeHeld() error {
+	held := t.held
+	if held == nil {
+		return nil
+	}
+
+	saved := *held
+	t.pending = t.pending[:0]
+	t.releaseHeld()
+	state := t.stateStack.Peek()
+	if t.held == nil && (state == TWS_IN_OBJECT_COMMA_SEEN || state == TWS_IN_ARRAY_COMMA_SEEN) {
+		// the comma is not rendered by a held container any more
+		t.pending = append(t.pending, cOMMA_BYTES...)
+	}
+
+	err := t.writePending()
+	if err != nil {
+		*held = saved
+		t.held = held
+	}
+	return err
+}
+
+// appendHeldItem appends an array item, packed into the current line if wrap is set and it fits.
+func (t *TokenWriter) appendHeldItem(value []byte, wrap bool) {
+	held := t.held
+	if held.written > 0 {
+		t.pending = append(t.pending, cOMMA_BYTES...)
+	}
+
+	spaceWidth := 0
+	if t.format.SpaceAfterComma {
+		spaceWidth = 1
+	}
+	if held.written == 0 || !wrap || t.pendingColumn()+spaceWidth+utf8.RuneCount(value) > t.format.MaxLineWidth {
+		t.pending = t.format.appendLineBreak(t.pending, held.level, held.level)
+	} else if t.format.SpaceAfterComma {
+		t.pending = append(t.pending, sPACE_BYTES...)
+	}
+	t.pending = append(t.pending, value...)
+	held.written++
+}
+
+func (t *TokenWriter) fitsInline() bool {
+	held := t.held
+	width := 2
+	for i, value := range held.values {
+		if i > 0 {
+			width += len(cOMMA_BYTES)
+			if t.format.SpaceAfterComma {
+				width += len(sPACE_BYTES)
+			}
+		}
+		width += utf8.RuneCount(value)
+	}
+
+	if t.format.MaxLineWidth > 0 && t.pendingColumn()-1+width > t.format.MaxLineWidth {
+		return false
+	}
+	return width <= t.format.InlineScalarArrayWidth
+}
+
+// appendInlineArray appends the held items and the closing bracket on the current line.
+func (t *TokenWriter) appendInlineArray() {
+	for i, value := range t.held.values {
+		if i > 0 {
+			t.pending = append(t.pending, cOMMA_BYTES...)
+			if t.format.SpaceAfterComma {
+				t.pending = append(t.pending, sPACE_BYTES...)
+			}
+		}
+		t.pending = append(t.pending, value...)
+	}
+	t.pending = append(t.pending, t.text...)
+	t.held = nil
+}
+
+// appendAlignedMembers appends the held object members with their values aligned,
+// a trailing key without value is appended with its colon if that has been written.
+func (t *TokenWriter) appendAlignedMembers() {
+	held := t.held
+	keyWidth := 0
+	for i := range held.values {
+		if width := utf8.RuneCount(held.keys[i]); width > keyWidth {
+			keyWidth = width
+		}
+	}
+
+	for i, key := range held.keys {
+		if i > 0 {
+			t.pending = append(t.pending, cOMMA_BYTES...)
+		}
+		t.pending = t.format.appendLineBreak(t.pending, held.level, held.level)
+		t.pending = append(t.pending, key...)
+		if i == len(held.values) && t.stateStack.Peek() == TWS_IN_OBJECT_KEY_SEEN {
+			// the colon has not been written yet
+			break
+		}
+		t.pending = append(t.pending, cOLON_BYTES...)
+		if t.format.SpaceAfterColon {
+			t.pending = append(t.pending, sPACE_BYTES...)
+		}
+		if i < len(held.values) {
+			t.pending = append(t.pending, bytes.Repeat(sPACE_BYTES, keyWidth-utf8.RuneCount(key))...)
+			t.pending = append(t.pending, held.values[i]...)
+		}
+	}
+}
+
+// pendingColumn returns the column after writing pending.
+func (t *TokenWriter) pendingColumn() int {
+	if i := bytes.LastIndexByte(t.pending, '\n'); i != -1 {
+		return utf8.RuneCount(t.pending[i+1:])
+	}
+
+	return t.column + utf8.RuneCount(t.pending)
+}
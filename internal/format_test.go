@@ -0,0 +1,267 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+)
+
+var updateGoldenFiles = flag.Bool("update", false, "update golden files in testdata")
+
+// writeAllTokenTypes writes a document containing every token type.
+func writeAllTokenTypes(t *testing.T, wr *TokenWriter) {
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndStringValue("string", "value"))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("integer", 1))
+	assert.NoError(t, wr.WriteKeyAndNumberValue("number", 2.5))
+	assert.NoError(t, wr.WriteKeyAndBigIntValue("bigInt", big.NewInt(3)))
+	assert.NoError(t, wr.WriteKeyAndBooleanValue("true", true))
+	assert.NoError(t, wr.WriteKeyAndBooleanValue("false", false))
+	assert.NoError(t, wr.WriteKeyAndNullValue("null"))
+	assert.NoError(t, wr.WriteKeyAndRawValue("raw", []byte("{\"a\":[1,2]}")))
+	assert.NoError(t, wr.WriteKey("emptyObject"))
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.WriteKey("emptyArray"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.WriteKey("array"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteStringValue("a"))
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.WriteNumberValue(1.5))
+	assert.NoError(t, wr.WriteBooleanValue(true))
+	assert.NoError(t, wr.WriteNullValue())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndBooleanValue("b", false))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(2))
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+}
+
+func testMatchesGoldenFile(t *testing.T, name string, configure func(wr *TokenWriter)) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	configure(wr)
+
+	writeAllTokenTypes(t, wr)
+
+	goldenFile := filepath.Join("testdata", "format", name+".golden")
+	if *updateGoldenFiles {
+		assert.NoError(t, ioutil.WriteFile(goldenFile, buf.Bytes(), 0644))
+	}
+
+	expected, err := ioutil.ReadFile(goldenFile)
+	assert.NoError(t, err)
+	assert.Equal(t, string(expected), buf.String())
+}
+
+func TestFormatsCompact(t *testing.T) {
+	testMatchesGoldenFile(t, "compact", func(wr *TokenWriter) {})
+}
+
+func TestFormatsCompactWithSpaces(t *testing.T) {
+	testMatchesGoldenFile(t, "compact_spaces", func(wr *TokenWriter) {
+		wr.SetSpaceAfterColon(true)
+		wr.SetSpaceAfterComma(true)
+	})
+}
+
+func TestFormatsTabIndent(t *testing.T) {
+	testMatchesGoldenFile(t, "indent_tab", func(wr *TokenWriter) {
+		wr.SetIndent("\t")
+	})
+}
+
+func TestFormatsPrefixAndIndent(t *testing.T) {
+	testMatchesGoldenFile(t, "prefix_indent", func(wr *TokenWriter) {
+		wr.SetPrefix("> ")
+		wr.SetIndent("  ")
+	})
+}
+
+func TestFormatsIndentWithoutSpaceAfterColon(t *testing.T) {
+	testMatchesGoldenFile(t, "indent_no_space", func(wr *TokenWriter) {
+		wr.SetIndent("  ")
+		wr.SetSpaceAfterColon(false)
+		wr.SetSpaceAfterComma(true)
+	})
+}
+
+func TestFormatsTrailingNewline(t *testing.T) {
+	testMatchesGoldenFile(t, "trailing_newline", func(wr *TokenWriter) {
+		wr.SetIndent("  ")
+		wr.SetTrailingNewline(true)
+	})
+}
+
+func TestWritesTrailingNewlineAfterScalar(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetTrailingNewline(true)
+
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.Equal(t, "1\n", buf.String())
+}
+
+func TestIndentMatchesEncodingJson(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetPrefix("> ")
+	wr.SetIndent("  ")
+	writeAllTokenTypes(t, wr)
+
+	compact := new(bytes.Buffer)
+	writeAllTokenTypes(t, NewTokenWriter(compact))
+	expected := new(bytes.Buffer)
+	assert.NoError(t, json.Indent(expected, compact.Bytes(), "> ", "  "))
+
+	assert.Equal(t, expected.String(), buf.String())
+}
+
+func TestFormatsJsonLinesPreset(t *testing.T) {
+	testMatchesGoldenFile(t, "json_lines", func(wr *TokenWriter) {
+		wr.SetFormatOptions(JSON_LINES_FORMAT)
+	})
+}
+
+func TestFormatsReadablePreset(t *testing.T) {
+	testMatchesGoldenFile(t, "readable", func(wr *TokenWriter) {
+		wr.SetFormatOptions(READABLE_FORMAT)
+	})
+}
+
+func testFormats(t *testing.T, options FormatOptions, expectedJson string, write func(wr *TokenWriter)) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetFormatOptions(options)
+
+	write(wr)
+
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, expectedJson, buf.String())
+}
+
+func TestWritesItemsOfTopLevelArrayOnSingleLines(t *testing.T) {
+	testFormats(t, JSON_LINES_FORMAT, "[\n{\"a\":1,\"b\":[1,2]},\n{\"a\":2,\"b\":[]}\n]\n", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteArrayStart())
+		assert.NoError(t, wr.WriteValue(map[string]interface{}{"a": 1, "b": []int{1, 2}}))
+		assert.NoError(t, wr.WriteValue(map[string]interface{}{"a": 2, "b": []int{}}))
+		assert.NoError(t, wr.WriteArrayEnd())
+	})
+}
+
+func TestKeepsShortScalarArraysInline(t *testing.T) {
+	options := FormatOptions{Indent: "  ", SpaceAfterColon: true, SpaceAfterComma: true, InlineScalarArrayWidth: 12}
+	testFormats(t, options, "{\n  \"short\": [1, 2, 3],\n  \"long\": [\n    1000,\n    2000,\n    3000\n  ],\n  \"nested\": [\n    1,\n    [2]\n  ]\n}", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKeyAndValue("short", []int{1, 2, 3}))
+		assert.NoError(t, wr.WriteKeyAndValue("long", []int{1000, 2000, 3000}))
+		assert.NoError(t, wr.WriteKeyAndValue("nested", []interface{}{1, []int{2}}))
+		assert.NoError(t, wr.WriteObjectEnd())
+	})
+}
+
+func TestWrapsLongScalarArrays(t *testing.T) {
+	options := FormatOptions{Indent: "  ", SpaceAfterComma: true, MaxLineWidth: 12}
+	testFormats(t, options, "[\n  100, 200,\n  300, 400,\n  500,\n  [],\n  600\n]", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteValue([]interface{}{100, 200, 300, 400, 500, []int{}, 600}))
+	})
+}
+
+func TestAlignsValuesOfObjectMembers(t *testing.T) {
+	options := FormatOptions{Indent: "  ", SpaceAfterColon: true, AlignKeys: true}
+	testFormats(t, options, "{\n  \"a\":   1,\n  \"bcd\": true,\n  \"nested\": {\n    \"x\": null\n  },\n  \"ef\":   \"g\",\n  \"hijk\": 2\n}", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+		assert.NoError(t, wr.WriteKeyAndBooleanValue("bcd", true))
+		assert.NoError(t, wr.WriteKey("nested"))
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKeyAndNullValue("x"))
+		assert.NoError(t, wr.WriteObjectEnd())
+		assert.NoError(t, wr.WriteKeyAndStringValue("ef", "g"))
+		assert.NoError(t, wr.WriteKeyAndIntegerValue("hijk", 2))
+		assert.NoError(t, wr.WriteObjectEnd())
+	})
+}
+
+func TestFlushWritesHeldArrayItems(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetFormatOptions(READABLE_FORMAT)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.WriteIntegerValue(2))
+	assert.NoError(t, wr.Flush())
+	assert.Equal(t, "[\n  1, 2", buf.String())
+
+	assert.NoError(t, wr.WriteIntegerValue(3))
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, "[\n  1, 2, 3\n]", buf.String())
+}
+
+func TestFlushWritesHeldCommaOfInlineArray(t *testing.T) {
+	options := FormatOptions{Indent: "  ", InlineScalarArrayWidth: 12}
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetFormatOptions(options)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.WriteToken(Token{Type: TT_COMMA}))
+	assert.NoError(t, wr.Flush())
+	assert.Equal(t, "[\n  1,", buf.String())
+
+	assert.NoError(t, wr.WriteIntegerValue(2))
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, "[\n  1,\n  2\n]", buf.String())
+}
+
+func TestFlushWritesHeldMembers(t *testing.T) {
+	options := FormatOptions{Indent: "  ", SpaceAfterColon: true, AlignKeys: true}
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetFormatOptions(options)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("bcd"))
+	assert.NoError(t, wr.Flush())
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"bcd\"", buf.String())
+
+	assert.NoError(t, wr.WriteIntegerValue(2))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("ef", 3))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("g", 4))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, "{\n  \"a\": 1,\n  \"bcd\": 2,\n  \"ef\": 3,\n  \"g\":  4\n}", buf.String())
+}
+
+func TestFailedFlushKeepsTokensHeld(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetFormatOptions(READABLE_FORMAT)
+	wr.SetLimits(Limits{MaxTotalBytes: 4})
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.WriteIntegerValue(2))
+	assert.EqualError(t, wr.Flush(), "maximum size of 4 bytes exceeded at $[1]")
+	assert.Equal(t, "[", buf.String())
+	assert.Equal(t, 2, len(wr.held.values))
+	assert.False(t, wr.held.wrapping)
+}
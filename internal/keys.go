@@ -0,0 +1,72 @@
+package internal
+
+type DuplicateKeyPolicy int
+
+const (
+	// DKP_ALLOW passes duplicate keys through, Decode keeps the last value.
+	DKP_ALLOW DuplicateKeyPolicy = iota
+	// DKP_ERROR fails with a DuplicateKeyError.
+	DKP_ERROR
+	// DKP_DROP silently drops members with a key already seen in the same object, the first one wins.
+	DKP_DROP
+)
+
+var duplicateKeyPolicyNames = []string{"DKP_ALLOW", "DKP_ERROR", "DKP_DROP"}
+
+func (p DuplicateKeyPolicy) Name() string {
+	return duplicateKeyPolicyNames[p]
+}
+
+// keySetStack holds the number of keys and the keys seen per open object. With maxKeys > 0
+// at most maxKeys keys are remembered per object, keys beyond are not checked any more.
+type keySetStack struct {
+	sets    []map[string]struct{}
+	counts  []int
+	maxKeys int
+}
+
+func (s *keySetStack) Push() {
+	// the set is allocated with the first key
+	s.sets = append(s.sets, nil)
+	s.counts = append(s.counts, 0)
+}
+
+func (s *keySetStack) Pop() {
+	s.sets[len(s.sets)-1] = nil
+	s.sets = s.sets[:len(s.sets)-1]
+	s.counts = s.counts[:len(s.counts)-1]
+}
+
+// Count counts a key of the innermost object and returns the number of keys so far.
+func (s *keySetStack) Count() int {
+	s.counts[len(s.counts)-1]++
+	return s.counts[len(s.counts)-1]
+}
+
+// Add remembers key for the innermost object and returns false if it has been seen before.
+func (s *keySetStack) Add(key string) bool {
+	set := s.sets[len(s.sets)-1]
+	if set == nil {
+		set = map[string]struct{}{}
+		s.sets[len(s.sets)-1] = set
+	}
+
+	if _, found := set[key]; found {
+		return false
+	}
+
+	if s.maxKeys <= 0 || len(set) < s.maxKeys {
+		set[key] = struct{}{}
+	}
+	return true
+}
+
+// Has reports if key has been remembered for the innermost object.
+func (s *keySetStack) Has(key string) bool {
+	if len(s.sets) == 0 {
+		return false
+	}
+
+	_, found := s.sets[len(s.sets)-1][key]
+	return found
+}
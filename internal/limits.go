@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"fmt"
+)
+
+// Limits bound the documents written or read, a limit of 0 is disabled.
+type Limits struct {
+	// MaxDepth is the maximum nesting depth of objects and arrays, the top-level container has depth 1.
+	MaxDepth int
+	// MaxStringLength is the maximum length of keys and string values in bytes, unescaped.
+	MaxStringLength int
+	// MaxKeysPerObject is the maximum number of members of a single object.
+	MaxKeysPerObject int
+	// MaxTotalBytes is the maximum size of the encoded document.
+	MaxTotalBytes int64
+}
+
+// MaxDepthError is returned if objects and arrays are nested deeper than Limits.MaxDepth.
+type MaxDepthError struct {
+	Limit int
+	Path  string
+}
+
+func (e *MaxDepthError) Error() string {
+	return limitErrorMessage(fmt.Sprintf("maximum depth of %d exceeded", e.Limit), e.Path)
+}
+
+// MaxStringLengthError is returned for a key or string value longer than Limits.MaxStringLength.
+type MaxStringLengthError struct {
+	Limit int
+	Path  string
+}
+
+func (e *MaxStringLengthError) Error() string {
+	return limitErrorMessage(fmt.Sprintf("maximum string length of %d exceeded", e.Limit), e.Path)
+}
+
+// MaxKeysPerObjectError is returned for an object with more than Limits.MaxKeysPerObject members.
+type MaxKeysPerObjectError struct {
+	Limit int
+	Path  string
+}
+
+func (e *MaxKeysPerObjectError) Error() string {
+	return limitErrorMessage(fmt.Sprintf("maximum number of %d keys per object exceeded", e.Limit), e.Path)
+}
+
+// MaxTotalBytesError is returned if the document gets larger than Limits.MaxTotalBytes.
+type MaxTotalBytesError struct {
+	Limit int64
+	Path  string
+}
+
+func (e *MaxTotalBytesError) Error() string {
+	return limitErrorMessage(fmt.Sprintf("maximum size of %d bytes exceeded", e.Limit), e.Path)
+}
+
+func limitErrorMessage(msg string, path string) string {
+	if path == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s at %s", msg, path)
+}
@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// LineError is returned for a line of newline delimited json that cannot be read or decoded,
+// except for syntax errors, which carry the line number themselves.
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("%v in line %d", e.Err, e.Line)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// LineReader reads newline delimited json (NDJSON, JSON Lines), every line is an independent
+// document. Blank lines are ignored. Syntax errors report the line number of the file, their
+// offset is relative to the start of the line.
+type LineReader struct {
+	rd     *bufio.Reader
+	buf    []byte
+	record []byte
+	reader *TokenReader
+	// tokens holds the tokens of the current line read while checking it for skipping,
+	// they are replayed with their path instead of parsing the line again
+	tokens    []Token
+	next      int
+	path      pathStack
+	replaying bool
+	line      int
+	// onRejected enables skipping bad lines, it is called for every line skipped
+	onRejected         func(line int, record []byte, err error)
+	duplicateKeyPolicy DuplicateKeyPolicy
+	limits             Limits
+}
+
+func NewLineReader(rd io.Reader) *LineReader {
+	return &LineReader{rd: bufio.NewReader(rd)}
+}
+
+// SetDuplicateKeyPolicy sets the duplicate key policy for every line.
+func (l *LineReader) SetDuplicateKeyPolicy(policy DuplicateKeyPolicy) {
+	l.duplicateKeyPolicy = policy
+}
+
+// SetLimits sets the limits checked for every line, MaxTotalBytes bounds the length of a line.
+func (l *LineReader) SetLimits(limits Limits) {
+	l.limits = limits
+}
+
+// SetSkipBadLines enables skipping lines that are not valid json or cannot be decoded,
+// onRejected is called with the line number, a copy of its content and the error for every
+// line skipped. nil disables skipping.
+func (l *LineReader) SetSkipBadLines(onRejected func(line int, record []byte, err error)) {
+	l.onRejected = onRejected
+}
+
+// Line returns the 1-based number of the current line.
+func (l *LineReader) Line() int {
+	return l.line
+}
+
+// Next advances to the next non blank line, io.EOF is returned after the last one.
+// If bad lines are skipped, lines that are not valid json are rejected here.
+func (l *LineReader) Next() error {
+	for {
+		err := l.readLine()
+		if err != nil {
+			return err
+		}
+
+		if l.onRejected == nil {
+			l.reader = l.newRecordReader()
+			return nil
+		}
+
+		err = l.validate()
+		if err == nil {
+			l.replaying = true
+			return nil
+		}
+		l.reject(err)
+	}
+}
+
+// ReadToken returns the next token of the current line, io.EOF after its value has been read completely.
+func (l *LineReader) ReadToken() (Token, error) {
+	if l.replaying {
+		return l.replayToken(true)
+	}
+	if l.reader == nil {
+		return Token{}, fmt.Errorf("no current line, Next must be called first")
+	}
+
+	token, err := l.reader.ReadToken()
+	return token, l.lineErr(err)
+}
+
+// PeekToken returns the next token of the current line without consuming it.
+func (l *LineReader) PeekToken() (Token, error) {
+	if l.replaying {
+		return l.replayToken(false)
+	}
+	if l.reader == nil {
+		return Token{}, fmt.Errorf("no current line, Next must be called first")
+	}
+
+	token, err := l.reader.PeekToken()
+	return token, l.lineErr(err)
+}
+
+// Path returns the structural position of the last token read from the current line.
+func (l *LineReader) Path() string {
+	if l.replaying {
+		return l.path.String()
+	}
+	if l.reader == nil {
+		return "$"
+	}
+
+	return l.reader.Path()
+}
+
+// Decode decodes the value of the current line into v like TokenReader.Decode
+// and checks that the line contains nothing else.
+func (l *LineReader) Decode(v interface{}) error {
+	if l.replaying {
+		err := l.stopReplaying()
+		if err != nil {
+			return l.lineErr(err)
+		}
+	}
+	if l.reader == nil {
+		return fmt.Errorf("no current line, Next must be called first")
+	}
+
+	err := l.reader.Decode(v)
+	if err == nil {
+		_, err = l.reader.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+	}
+
+	return l.lineErr(err)
+}
+
+// DecodeNext advances to the next line and decodes it into v, io.EOF is returned after the
+// last line. If bad lines are skipped, lines that cannot be decoded into v are rejected too.
+func (l *LineReader) DecodeNext(v interface{}) error {
+	for {
+		// decoding checks the line completely, it is not validated before
+		err := l.readLine()
+		if err != nil {
+			return err
+		}
+
+		l.reader = l.newRecordReader()
+		err = l.Decode(v)
+		if err == nil || l.onRejected == nil {
+			return err
+		}
+		l.reject(err)
+	}
+}
+
+// reject passes a copy of the current line to onRejected, the line buffer is reused.
+func (l *LineReader) reject(err error) {
+	l.onRejected(l.line, append([]byte{}, l.record...), err)
+}
+
+func (l *LineReader) newRecordReader() *TokenReader {
+	reader := NewTokenReader(bytes.NewReader(l.record))
+	reader.SetDuplicateKeyPolicy(l.duplicateKeyPolicy)
+	reader.SetLimits(l.limits)
+	reader.pos.line = l.line
+	return reader
+}
+
+// validate reads all tokens of the current line into tokens.
+func (l *LineReader) validate() error {
+	reader := l.newRecordReader()
+	for {
+		token, err := reader.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return l.lineErr(err)
+		}
+		l.tokens = append(l.tokens, token)
+	}
+}
+
+// replayToken returns the next token read by validate, io.EOF after the last one.
+// If consume is set, the token is consumed and the path advanced to it.
+func (l *LineReader) replayToken(consume bool) (Token, error) {
+	if l.next == len(l.tokens) {
+		return Token{}, io.EOF
+	}
+
+	token := l.tokens[l.next]
+	if !consume {
+		return token, nil
+	}
+
+	l.next++
+	switch token.Type {
+	case TT_OBJECT_START:
+		l.path.NextValue()
+		l.path.PushObject()
+	case TT_ARRAY_START:
+		l.path.NextValue()
+		l.path.PushArray()
+	case TT_OBJECT_END, TT_ARRAY_END:
+		l.path.Pop()
+	case TT_KEY:
+		l.path.SetKey(token.Value)
+	default:
+		l.path.NextValue()
+	}
+	return token, nil
+}
+
+// stopReplaying parses the current line up to the tokens replayed so far, for decoding the rest.
+func (l *LineReader) stopReplaying() error {
+	l.replaying = false
+	l.reader = l.newRecordReader()
+	for i := 0; i < l.next; i++ {
+		_, err := l.reader.ReadToken()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *LineReader) lineErr(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if _, isSyntaxErr := err.(*SyntaxError); isSyntaxErr {
+		return err
+	}
+
+	return &LineError{Line: l.line, Err: err}
+}
+
+// readLine reads the next non blank line into record, without the line break.
+func (l *LineReader) readLine() error {
+	l.reader = nil
+	l.tokens = l.tokens[:0]
+	l.next = 0
+	l.path = l.path[:0]
+	l.replaying = false
+	for {
+		l.line++
+		tooLong, err := l.readLineBytes()
+		if err == io.EOF {
+			l.line--
+		}
+		if err != nil {
+			return err
+		}
+
+		if tooLong {
+			err := &LineError{Line: l.line, Err: &MaxTotalBytesError{Limit: l.limits.MaxTotalBytes}}
+			if l.onRejected == nil {
+				return err
+			}
+			l.reject(err)
+			continue
+		}
+
+		if len(bytes.TrimSpace(l.record)) > 0 {
+			return nil
+		}
+	}
+}
+
+// readLineBytes reads a line into record. Lines longer than Limits.MaxTotalBytes are consumed
+// up to their end, but only the allowed number of bytes is kept.
+func (l *LineReader) readLineBytes() (bool, error) {
+	l.buf = l.buf[:0]
+	for {
+		chunk, err := l.rd.ReadSlice('\n')
+		if err == io.EOF && len(chunk) == 0 && len(l.buf) == 0 {
+			return false, io.EOF
+		}
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return false, err
+		}
+
+		// keep room for the line break
+		if keep := l.limits.MaxTotalBytes + 2; l.limits.MaxTotalBytes > 0 && int64(len(l.buf)+len(chunk)) > keep {
+			chunk = chunk[:keep-int64(len(l.buf))]
+		}
+		l.buf = append(l.buf, chunk...)
+
+		if err != bufio.ErrBufferFull {
+			break
+		}
+	}
+
+	l.record = bytes.TrimSuffix(bytes.TrimSuffix(l.buf, lINE_BREAK_BYTES), []byte("\r"))
+	return l.limits.MaxTotalBytes > 0 && int64(len(l.record)) > l.limits.MaxTotalBytes, nil
+}
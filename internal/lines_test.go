@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+type lineRecord struct {
+	Name string `json:"name"`
+}
+
+func TestReadsLines(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{\"name\":\"a\"}\n\n  \r\n{\"name\":\"b\"}\r\n{\"name\":\"c\"}"))
+
+	names := []string{}
+	lines := []int{}
+	for {
+		var record lineRecord
+		err := rd.DecodeNext(&record)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, record.Name)
+		lines = append(lines, rd.Line())
+	}
+
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+	assert.Equal(t, []int{1, 4, 5}, lines)
+}
+
+func TestReadsLineTokens(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("[1]\n2\n"))
+
+	assert.NoError(t, rd.Next())
+	token, err := rd.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, TT_ARRAY_START, token.Type)
+
+	assert.NoError(t, rd.Next())
+	token, err = rd.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, Token{Type: TT_INTEGER_VALUE, Value: "2"}, token)
+	_, err = rd.ReadToken()
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, io.EOF, rd.Next())
+}
+
+func TestReportsLineOfSyntaxError(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{}\n{\"name\":}\n"))
+
+	assert.NoError(t, rd.DecodeNext(&lineRecord{}))
+	err := rd.DecodeNext(&lineRecord{})
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, 2, syntaxErr.Line)
+	assert.Equal(t, 9, syntaxErr.Column)
+}
+
+func TestReportsLineOfDecodeError(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{\"name\":1}\n"))
+
+	err := rd.DecodeNext(&lineRecord{})
+
+	var lineErr *LineError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Equal(t, 1, lineErr.Line)
+}
+
+func TestRejectsTrailingDataInLine(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{} {}\n"))
+
+	assert.Error(t, rd.DecodeNext(&lineRecord{}))
+}
+
+func TestSkipsBadLines(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{\"name\":\"a\"}\n{\"name\":\n{\"name\":2}\n" + strings.Repeat("x", 40) + "\n{\"name\":\"b\"}\n"))
+	rd.SetLimits(Limits{MaxTotalBytes: 32})
+	rejected := []int{}
+	rd.SetSkipBadLines(func(line int, record []byte, err error) {
+		assert.Error(t, err)
+		rejected = append(rejected, line)
+	})
+
+	names := []string{}
+	for {
+		var record lineRecord
+		err := rd.DecodeNext(&record)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, record.Name)
+	}
+
+	assert.Equal(t, []string{"a", "b"}, names)
+	assert.Equal(t, []int{2, 3, 4}, rejected)
+}
+
+func TestPassesCopiesOfRejectedLines(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{\"name\":\n[1,\n{\"name\":\"a\"}\n"))
+	records := [][]byte{}
+	rd.SetSkipBadLines(func(line int, record []byte, err error) {
+		records = append(records, record)
+	})
+
+	var record lineRecord
+	assert.NoError(t, rd.DecodeNext(&record))
+	assert.Equal(t, [][]byte{[]byte("{\"name\":"), []byte("[1,")}, records)
+}
+
+func TestReadsTokensOfCheckedLines(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("[1,\n{\"a\":[true]}\n"))
+	rd.SetSkipBadLines(func(line int, record []byte, err error) {})
+
+	assert.NoError(t, rd.Next())
+	assert.Equal(t, 2, rd.Line())
+	types := []TokenType{}
+	paths := []string{}
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		types = append(types, token.Type)
+		paths = append(paths, rd.Path())
+	}
+
+	assert.Equal(t, []TokenType{TT_OBJECT_START, TT_KEY, TT_ARRAY_START, TT_TRUE_VALUE, TT_ARRAY_END, TT_OBJECT_END}, types)
+	assert.Equal(t, []string{"$", "$.a", "$.a", "$.a[0]", "$.a", "$"}, paths)
+	assert.Equal(t, io.EOF, rd.Next())
+}
+
+func TestDecodesCheckedLine(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("{\"name\":\"a\"}\n"))
+	rd.SetSkipBadLines(func(line int, record []byte, err error) {})
+
+	assert.NoError(t, rd.Next())
+	token, err := rd.PeekToken()
+	assert.NoError(t, err)
+	assert.Equal(t, TT_OBJECT_START, token.Type)
+
+	var record lineRecord
+	assert.NoError(t, rd.Decode(&record))
+	assert.Equal(t, "a", record.Name)
+}
+
+func TestFailsOnTooLongLine(t *testing.T) {
+	rd := NewLineReader(strings.NewReader("\"" + strings.Repeat("x", 8) + "\"\nnull\n"))
+	rd.SetLimits(Limits{MaxTotalBytes: 8})
+
+	err := rd.Next()
+
+	var sizeErr *MaxTotalBytesError
+	assert.True(t, errors.As(err, &sizeErr))
+	assert.EqualError(t, err, "maximum size of 8 bytes exceeded in line 1")
+	assert.NoError(t, rd.Next())
+	assert.Equal(t, 2, rd.Line())
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+type NonFiniteNumberPolicy int
+
+const (
+	NFP_ERROR NonFiniteNumberPolicy = iota
+	NFP_NULL
+	NFP_STRING
+)
+
+var nonFiniteNumberPolicyNames = []string{"NFP_ERROR", "NFP_NULL", "NFP_STRING"}
+
+func (p NonFiniteNumberPolicy) Name() string {
+	return nonFiniteNumberPolicyNames[p]
+}
+
+const (
+	DEFAULT_MIN_FIXED_NUMBER = 1e-6
+	DEFAULT_MAX_FIXED_NUMBER = 1e21
+)
+
+// MAX_SAFE_INTEGER is the largest integer javascript can represent exactly.
+const MAX_SAFE_INTEGER = 1<<53 - 1
+
+var (
+	maxSafeBigInt = big.NewInt(MAX_SAFE_INTEGER)
+	minSafeBigInt = big.NewInt(-MAX_SAFE_INTEGER)
+)
+
+type numberOptions struct {
+	nonFinitePolicy        NonFiniteNumberPolicy
+	minFixed               float64
+	maxFixed               float64
+	largeIntegersAsStrings bool
+}
+
+// appendFloat appends the shortest representation of f that round trips with the given
+// bitSize, using fixed notation for minFixed <= |f| < maxFixed and exponent notation otherwise.
+func appendFloat(dst []byte, f float64, bitSize int, opts *numberOptions) []byte {
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < opts.minFixed || abs >= opts.maxFixed) {
+		format = 'e'
+	}
+
+	dst = strconv.AppendFloat(dst, f, format, -1, bitSize)
+	if format == 'e' {
+		// clean up e-09 to e-9 and e+09 to e+9
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+
+	return dst
+}
+
+// nonFiniteName returns the javascript name of NaN and ±Inf.
+func nonFiniteName(f float64) string {
+	if math.IsNaN(f) {
+		return "NaN"
+	}
+	if f > 0 {
+		return "Infinity"
+	}
+	return "-Infinity"
+}
+
+var canonicalNumberOptions = numberOptions{nonFinitePolicy: NFP_ERROR, minFixed: DEFAULT_MIN_FIXED_NUMBER, maxFixed: DEFAULT_MAX_FIXED_NUMBER}
+
+// appendCanonicalNumber appends the number literal s as IEEE 754 double formatted like ECMAScript's
+// Number.prototype.toString, as required by RFC 8785.
+func appendCanonicalNumber(dst []byte, s string) ([]byte, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return dst, fmt.Errorf("number %s is not representable as double", s)
+	}
+
+	if f == 0 {
+		// no negative zero
+		f = 0
+	}
+	return appendFloat(dst, f, 64, &canonicalNumberOptions), nil
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func testFormatsFloat(t *testing.T, opts numberOptions, value float64, expected string) {
+	assert.Equal(t, expected, string(appendFloat(nil, value, 64, &opts)))
+}
+
+var defaultNumberOptions = numberOptions{nonFinitePolicy: NFP_ERROR, minFixed: DEFAULT_MIN_FIXED_NUMBER, maxFixed: DEFAULT_MAX_FIXED_NUMBER}
+
+func TestFormatsFloatShortest(t *testing.T) {
+	testFormatsFloat(t, defaultNumberOptions, 0, "0")
+	testFormatsFloat(t, defaultNumberOptions, 1.5, "1.5")
+	testFormatsFloat(t, defaultNumberOptions, -2, "-2")
+	testFormatsFloat(t, defaultNumberOptions, 0.1, "0.1")
+	testFormatsFloat(t, defaultNumberOptions, 123456789.123456789, "123456789.12345679")
+	testFormatsFloat(t, defaultNumberOptions, math.MaxFloat64, "1.7976931348623157e+308")
+}
+
+func TestFormatsFloatWithDefaultThresholds(t *testing.T) {
+	testFormatsFloat(t, defaultNumberOptions, 0.000001, "0.000001")
+	testFormatsFloat(t, defaultNumberOptions, 0.0000001, "1e-7")
+	testFormatsFloat(t, defaultNumberOptions, 1e20, "100000000000000000000")
+	testFormatsFloat(t, defaultNumberOptions, 1e21, "1e+21")
+	testFormatsFloat(t, defaultNumberOptions, 1.5e-300, "1.5e-300")
+}
+
+func TestFormatsFloatWithCustomThresholds(t *testing.T) {
+	opts := numberOptions{nonFinitePolicy: NFP_ERROR, minFixed: 0.01, maxFixed: 1000}
+	testFormatsFloat(t, opts, 0.5, "0.5")
+	testFormatsFloat(t, opts, 0.001, "1e-3")
+	testFormatsFloat(t, opts, 1000, "1e+3")
+	testFormatsFloat(t, opts, 0, "0")
+}
+
+func TestFormatsFloat32Shortest(t *testing.T) {
+	assert.Equal(t, "0.1", string(appendFloat(nil, float64(float32(0.1)), 32, &defaultNumberOptions)))
+}
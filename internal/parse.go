@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"errors"
+	"io"
+)
+
+var (
+	// SKIP_VALUE returned by Handler.OnObjectStart, OnArrayStart or OnKey skips the object,
+	// array or member value, its tokens are not reported.
+	SKIP_VALUE = errors.New("skip value")
+	// STOP returned by a Handler method ends Parse without error.
+	STOP = errors.New("stop parsing")
+)
+
+// Handler receives the tokens of a document from Parse, its methods mirror the ones of TokenWriter.
+// Numbers are reported with their literal text, so no precision is lost.
+type Handler interface {
+	OnObjectStart() error
+	OnObjectEnd() error
+	OnKey(key string) error
+	OnArrayStart() error
+	OnArrayEnd() error
+	OnString(value string) error
+	OnNumber(value string) error
+	OnBool(value bool) error
+	OnNull() error
+}
+
+// DocumentEndHandler is implemented by handlers interested in the end of every top-level value
+// in multi document mode.
+type DocumentEndHandler interface {
+	OnDocumentEnd() error
+}
+
+// Parse reads the remaining input and pushes its tokens to h. An error returned by h other
+// than SKIP_VALUE and STOP ends parsing and is returned.
+func (r *TokenReader) Parse(h Handler) error {
+	for {
+		token, err := r.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = handleToken(h, token)
+		if errors.Is(err, SKIP_VALUE) {
+			err = r.skipHandled(token)
+		}
+		if errors.Is(err, STOP) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func handleToken(h Handler, token Token) error {
+	switch token.Type {
+	case TT_OBJECT_START:
+		return h.OnObjectStart()
+	case TT_OBJECT_END:
+		return h.OnObjectEnd()
+	case TT_KEY:
+		return h.OnKey(token.Value)
+	case TT_ARRAY_START:
+		return h.OnArrayStart()
+	case TT_ARRAY_END:
+		return h.OnArrayEnd()
+	case TT_STRING_VALUE:
+		return h.OnString(token.Value)
+	case TT_NUMBER_VALUE, TT_INTEGER_VALUE:
+		return h.OnNumber(token.Value)
+	case TT_TRUE_VALUE:
+		return h.OnBool(true)
+	case TT_FALSE_VALUE:
+		return h.OnBool(false)
+	case TT_NULL_VALUE:
+		return h.OnNull()
+	case TT_DOCUMENT_END:
+		if documentEndHandler, ok := h.(DocumentEndHandler); ok {
+			return documentEndHandler.OnDocumentEnd()
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// skipHandled skips the subtree started by token, a scalar value has nothing left to skip.
+func (r *TokenReader) skipHandled(token Token) error {
+	switch token.Type {
+	case TT_OBJECT_START, TT_ARRAY_START:
+		return r.skipValue(token)
+	case TT_KEY:
+		valueToken, err := r.ReadToken()
+		if err != nil {
+			return err
+		}
+		return r.skipValue(valueToken)
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+// recordingHandler records the events it receives and returns the configured error for an event.
+type recordingHandler struct {
+	events  []string
+	results map[string]error
+}
+
+func (h *recordingHandler) on(event string) error {
+	h.events = append(h.events, event)
+	return h.results[event]
+}
+
+func (h *recordingHandler) OnObjectStart() error        { return h.on("{") }
+func (h *recordingHandler) OnObjectEnd() error          { return h.on("}") }
+func (h *recordingHandler) OnKey(key string) error      { return h.on("key " + key) }
+func (h *recordingHandler) OnArrayStart() error         { return h.on("[") }
+func (h *recordingHandler) OnArrayEnd() error           { return h.on("]") }
+func (h *recordingHandler) OnString(value string) error { return h.on("string " + value) }
+func (h *recordingHandler) OnNumber(value string) error { return h.on("number " + value) }
+func (h *recordingHandler) OnBool(value bool) error     { return h.on(fmt.Sprintf("bool %t", value)) }
+func (h *recordingHandler) OnNull() error               { return h.on("null") }
+func (h *recordingHandler) OnDocumentEnd() error        { return h.on("end") }
+
+func parse(json string, results map[string]error) ([]string, error) {
+	h := &recordingHandler{results: results}
+	err := NewTokenReader(strings.NewReader(json)).Parse(h)
+	return h.events, err
+}
+
+func TestParsesDocument(t *testing.T) {
+	events, err := parse(`{"a":[1,2.50,"x"],"b":{"c":true,"d":false},"e":null}`, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{", "key a", "[", "number 1", "number 2.50", "string x", "]",
+		"key b", "{", "key c", "bool true", "key d", "bool false", "}", "key e", "null", "}"}, events)
+}
+
+func TestSkipsSubtreeOnSkipValue(t *testing.T) {
+	events, err := parse(`{"a":{"x":[1]},"b":[{"y":2}],"c":3}`, map[string]error{"key a": SKIP_VALUE, "[": SKIP_VALUE})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"{", "key a", "key b", "[", "key c", "number 3", "}"}, events)
+}
+
+func TestStopsParsing(t *testing.T) {
+	events, err := parse(`[1,2,3`, map[string]error{"number 2": STOP})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"[", "number 1", "number 2"}, events)
+}
+
+func TestReturnsHandlerError(t *testing.T) {
+	failure := errors.New("failure")
+
+	events, err := parse(`[1,2]`, map[string]error{"number 1": failure})
+
+	assert.Equal(t, failure, err)
+	assert.Equal(t, []string{"[", "number 1"}, events)
+}
+
+func TestReportsDocumentEnds(t *testing.T) {
+	h := &recordingHandler{}
+	rd := NewTokenReader(strings.NewReader(`1 "x"`))
+	rd.SetMultiDocument(true)
+
+	assert.NoError(t, rd.Parse(h))
+	assert.Equal(t, []string{"number 1", "end", "string x", "end"}, h.events)
+}
+
+func TestFailsParsingMalformedInput(t *testing.T) {
+	_, err := parse(`[1,]`, nil)
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+}
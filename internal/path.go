@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"strconv"
+)
+
+type pathSegment struct {
+	key     string
+	hasKey  bool
+	index   int
+	isIndex bool
+}
+
+// pathStack tracks the structural position inside a document, like $.items[42].name.
+type pathStack []pathSegment
+
+func (p *pathStack) PushObject() {
+	*p = append(*p, pathSegment{key: "", hasKey: false, index: -1, isIndex: false})
+}
+
+func (p *pathStack) PushArray() {
+	*p = append(*p, pathSegment{key: "", hasKey: false, index: -1, isIndex: true})
+}
+
+func (p *pathStack) Pop() {
+	*p = (*p)[:len(*p)-1]
+}
+
+func (p *pathStack) SetKey(key string) {
+	(*p)[len(*p)-1].key = key
+	(*p)[len(*p)-1].hasKey = true
+}
+
+// NextValue advances the index if the current container is an array.
+func (p *pathStack) NextValue() {
+	if len(*p) > 0 && (*p)[len(*p)-1].isIndex {
+		(*p)[len(*p)-1].index++
+	}
+}
+
+func (p *pathStack) String() string {
+	buf := []byte("$")
+	for _, segment := range *p {
+		if segment.isIndex {
+			if segment.index >= 0 {
+				buf = append(buf, '[')
+				buf = strconv.AppendInt(buf, int64(segment.index), 10)
+				buf = append(buf, ']')
+			}
+		} else if segment.hasKey {
+			if isIdentifier(segment.key) {
+				buf = append(buf, '.')
+				buf = append(buf, segment.key...)
+			} else {
+				buf = append(buf, '[')
+				buf, _ = appendQuoted(buf, segment.key, &escapeOptions{})
+				buf = append(buf, ']')
+			}
+		}
+	}
+
+	return string(buf)
+}
+
+func isIdentifier(key string) bool {
+	if key == "" {
+		return false
+	}
+
+	for i, c := range key {
+		if !(c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
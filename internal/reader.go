@@ -0,0 +1,755 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type tokenReaderState int
+
+type tokenReaderStateStack []tokenReaderState
+
+func (s *tokenReaderStateStack) Peek() tokenReaderState {
+	if len(*s) == 0 {
+		panic(fmt.Errorf("empty stack"))
+	}
+
+	return (*s)[len(*s)-1]
+}
+
+func (s *tokenReaderStateStack) IsEmpty() bool {
+	return len(*s) == 0
+}
+
+func (s *tokenReaderStateStack) Pop() tokenReaderState {
+	state := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return state
+}
+
+func (s *tokenReaderStateStack) Push(state tokenReaderState) {
+	*s = append(*s, state)
+}
+
+func (s *tokenReaderStateStack) Replace(state tokenReaderState) {
+	(*s)[len(*s)-1] = state
+}
+
+const (
+	TRS_INITIAL tokenReaderState = iota
+	TRS_IN_OBJECT
+	TRS_IN_OBJECT_KEY_SEEN
+	TRS_IN_OBJECT_COLON_SEEN
+	TRS_IN_OBJECT_PAIR_SEEN
+	TRS_IN_OBJECT_COMMA_SEEN
+	TRS_IN_ARRAY
+	TRS_IN_ARRAY_ITEM_SEEN
+	TRS_IN_ARRAY_COMMA_SEEN
+	TRS_END
+)
+
+var tokenReaderStateNames = []string{"TRS_INITIAL", "TRS_IN_OBJECT", "TRS_IN_OBJECT_KEY_SEEN", "TRS_IN_OBJECT_COLON_SEEN", "TRS_IN_OBJECT_PAIR_SEEN", "TRS_IN_OBJECT_COMMA_SEEN", "TRS_IN_ARRAY", "TRS_IN_ARRAY_ITEM_SEEN", "TRS_IN_ARRAY_COMMA_SEEN", "TRS_END"}
+
+func (s tokenReaderState) Name() string {
+	return tokenReaderStateNames[s]
+}
+
+type position struct {
+	offset int64
+	line   int
+	column int
+}
+
+// TokenReader reads a json document token by token from an io.Reader. Only the
+// state stack and the value of the current token are held in memory.
+type TokenReader struct {
+	rd           *bufio.Reader
+	stateStack   tokenReaderStateStack
+	path         pathStack
+	buf          []byte
+	pos          position
+	lastPos      position
+	tokenStart   int64
+	trackOffsets bool
+	peeked       bool
+	peekedToken  Token
+	peekedErr    error
+	// stateBeforePeek is the state as seen by the caller while a token is peeked
+	stateBeforePeek tokenReaderState
+	decodeErr       error
+	// keys holds the keys read per open object if duplicate keys are not allowed
+	duplicateKeyPolicy DuplicateKeyPolicy
+	keys               keySetStack
+	limits             Limits
+	// multiDocument enables reading any number of top-level values, each followed by a TT_DOCUMENT_END token
+	multiDocument bool
+}
+
+func NewTokenReader(rd io.Reader) *TokenReader {
+	return &TokenReader{rd: bufio.NewReader(rd), stateStack: tokenReaderStateStack{TRS_INITIAL}, pos: position{offset: 0, line: 1, column: 1}}
+}
+
+// SetTrackOffsets enables filling Start and End of the returned tokens with their byte offsets.
+func (r *TokenReader) SetTrackOffsets(trackOffsets bool) {
+	r.trackOffsets = trackOffsets
+}
+
+// SetDuplicateKeyPolicy controls if a key read twice in the same object is passed through,
+// fails with a DuplicateKeyError or is skipped together with its value.
+func (r *TokenReader) SetDuplicateKeyPolicy(policy DuplicateKeyPolicy) {
+	r.duplicateKeyPolicy = policy
+}
+
+// SetMaxTrackedKeys bounds the memory used for duplicate key detection to maxKeys keys
+// per object, keys beyond are not checked. 0 tracks all keys.
+func (r *TokenReader) SetMaxTrackedKeys(maxKeys int) {
+	r.keys.maxKeys = maxKeys
+}
+
+// SetLimits sets limits checked while reading, for handling untrusted input.
+func (r *TokenReader) SetLimits(limits Limits) {
+	r.limits = limits
+}
+
+// SetMultiDocument enables reading a stream of top-level values with arbitrary or no whitespace
+// between them, like newline delimited or concatenated json. Every top-level value is followed
+// by a TT_DOCUMENT_END token, io.EOF is returned at the end of input after a complete value.
+func (r *TokenReader) SetMultiDocument(multiDocument bool) {
+	r.multiDocument = multiDocument
+}
+
+// Path returns the structural position of the last token read, like $.items[42].name.
+func (r *TokenReader) Path() string {
+	return r.path.String()
+}
+
+// errorf returns a SyntaxError located at the last byte read.
+func (r *TokenReader) errorf(format string, args ...interface{}) error {
+	return r.syntaxError(r.lastPos, format, args...)
+}
+
+// eofErrorf returns a SyntaxError located at the end of input.
+func (r *TokenReader) eofErrorf(format string, args ...interface{}) error {
+	return r.syntaxError(r.pos, format, args...)
+}
+
+func (r *TokenReader) syntaxError(pos position, format string, args ...interface{}) error {
+	return &SyntaxError{Msg: fmt.Sprintf(format, args...), Offset: pos.offset, Line: pos.line, Column: pos.column, Path: r.path.String()}
+}
+
+func (r *TokenReader) checkTokenAllowed(currTokenType TokenType, allowedStates ...tokenReaderState) error {
+	currState := r.stateStack.Peek()
+	for _, allowedState := range allowedStates {
+		if allowedState == currState {
+			return nil
+		}
+	}
+
+	return r.errorf("%s not allowed in %s", currTokenType.Name(), currState.Name())
+}
+
+// beginValue checks that a value is allowed and advances the path to it.
+func (r *TokenReader) beginValue(currTokenType TokenType) error {
+	err := r.checkTokenAllowed(currTokenType, TRS_INITIAL, TRS_IN_OBJECT_COLON_SEEN, TRS_IN_ARRAY, TRS_IN_ARRAY_COMMA_SEEN)
+	if err != nil {
+		return err
+	}
+
+	r.path.NextValue()
+	return nil
+}
+
+func (r *TokenReader) valueSeen() {
+	switch r.stateStack.Peek() {
+	case TRS_INITIAL:
+		r.stateStack.Replace(TRS_END)
+	case TRS_IN_OBJECT_COLON_SEEN:
+		r.stateStack.Replace(TRS_IN_OBJECT_PAIR_SEEN)
+	case TRS_IN_ARRAY, TRS_IN_ARRAY_COMMA_SEEN:
+		r.stateStack.Replace(TRS_IN_ARRAY_ITEM_SEEN)
+	}
+}
+
+// ReadToken returns the next token of the document. Colons and commas are consumed
+// silently. io.EOF is returned after the top-level value has been read completely.
+// Malformed input is reported as *SyntaxError.
+func (r *TokenReader) ReadToken() (Token, error) {
+	if r.peeked {
+		r.peeked = false
+		return r.peekedToken, r.peekedErr
+	}
+
+	token, err := r.readToken()
+	if err != nil {
+		return token, err
+	}
+
+	if r.trackOffsets {
+		token.Start = r.tokenStart
+		token.End = r.pos.offset
+	}
+
+	return token, nil
+}
+
+// PeekToken returns the next token without consuming it.
+func (r *TokenReader) PeekToken() (Token, error) {
+	if !r.peeked {
+		r.stateBeforePeek = r.stateStack.Peek()
+		r.peekedToken, r.peekedErr = r.ReadToken()
+		r.peeked = true
+	}
+
+	return r.peekedToken, r.peekedErr
+}
+
+// callerState returns the state after the last token consumed by the caller.
+func (r *TokenReader) callerState() tokenReaderState {
+	if r.peeked {
+		return r.stateBeforePeek
+	}
+
+	return r.stateStack.Peek()
+}
+
+func (r *TokenReader) readToken() (Token, error) {
+	if r.multiDocument && r.stateStack.Peek() == TRS_END {
+		r.stateStack.Replace(TRS_INITIAL)
+		r.tokenStart = r.pos.offset
+		return Token{Type: TT_DOCUMENT_END, Value: ""}, nil
+	}
+
+	for {
+		b, err := r.skipWhitespace()
+		if err == io.EOF {
+			if r.stateStack.Peek() == TRS_END || (r.multiDocument && r.stateStack.Peek() == TRS_INITIAL) {
+				return Token{}, io.EOF
+			}
+			return Token{}, r.eofErrorf("unexpected end of input in %s", r.stateStack.Peek().Name())
+		}
+		if err != nil {
+			return Token{}, err
+		}
+
+		if r.stateStack.Peek() == TRS_END {
+			return Token{}, r.errorf("unexpected character %q after top-level value", b)
+		}
+
+		r.tokenStart = r.lastPos.offset
+
+		switch {
+		case b == '{':
+			err := r.beginValue(TT_OBJECT_START)
+			if err != nil {
+				return Token{}, err
+			}
+
+			err = r.checkDepth()
+			if err != nil {
+				return Token{}, err
+			}
+
+			r.stateStack.Push(TRS_IN_OBJECT)
+			r.path.PushObject()
+			r.keys.Push()
+			return Token{Type: TT_OBJECT_START, Value: ""}, nil
+		case b == '}':
+			err := r.checkTokenAllowed(TT_OBJECT_END, TRS_IN_OBJECT, TRS_IN_OBJECT_PAIR_SEEN)
+			if err != nil {
+				return Token{}, err
+			}
+
+			_ = r.stateStack.Pop()
+			r.path.Pop()
+			r.keys.Pop()
+			r.valueSeen()
+			return Token{Type: TT_OBJECT_END, Value: ""}, nil
+		case b == '[':
+			err := r.beginValue(TT_ARRAY_START)
+			if err != nil {
+				return Token{}, err
+			}
+
+			err = r.checkDepth()
+			if err != nil {
+				return Token{}, err
+			}
+
+			r.stateStack.Push(TRS_IN_ARRAY)
+			r.path.PushArray()
+			return Token{Type: TT_ARRAY_START, Value: ""}, nil
+		case b == ']':
+			err := r.checkTokenAllowed(TT_ARRAY_END, TRS_IN_ARRAY, TRS_IN_ARRAY_ITEM_SEEN)
+			if err != nil {
+				return Token{}, err
+			}
+
+			_ = r.stateStack.Pop()
+			r.path.Pop()
+			r.valueSeen()
+			return Token{Type: TT_ARRAY_END, Value: ""}, nil
+		case b == ':':
+			err := r.checkTokenAllowed(TT_COLON, TRS_IN_OBJECT_KEY_SEEN)
+			if err != nil {
+				return Token{}, err
+			}
+
+			r.stateStack.Replace(TRS_IN_OBJECT_COLON_SEEN)
+		case b == ',':
+			err := r.checkTokenAllowed(TT_COMMA, TRS_IN_OBJECT_PAIR_SEEN, TRS_IN_ARRAY_ITEM_SEEN)
+			if err != nil {
+				return Token{}, err
+			}
+
+			if r.stateStack.Peek() == TRS_IN_OBJECT_PAIR_SEEN {
+				r.stateStack.Replace(TRS_IN_OBJECT_COMMA_SEEN)
+			} else {
+				r.stateStack.Replace(TRS_IN_ARRAY_COMMA_SEEN)
+			}
+		case b == '"':
+			state := r.stateStack.Peek()
+			if state == TRS_IN_OBJECT || state == TRS_IN_OBJECT_COMMA_SEEN {
+				value, err := r.readString()
+				if err != nil {
+					return Token{}, err
+				}
+
+				r.stateStack.Replace(TRS_IN_OBJECT_KEY_SEEN)
+				r.path.SetKey(value)
+				if r.keys.Count() > r.limits.MaxKeysPerObject && r.limits.MaxKeysPerObject > 0 {
+					return Token{}, &MaxKeysPerObjectError{Limit: r.limits.MaxKeysPerObject, Path: r.path.String()}
+				}
+				if r.duplicateKeyPolicy != DKP_ALLOW && !r.keys.Add(value) {
+					if r.duplicateKeyPolicy == DKP_ERROR {
+						return Token{}, &DuplicateKeyError{Key: value, Path: r.path.String()}
+					}
+
+					err := r.skipMember()
+					if err != nil {
+						return Token{}, err
+					}
+					continue
+				}
+				return Token{Type: TT_KEY, Value: value}, nil
+			}
+
+			err := r.beginValue(TT_STRING_VALUE)
+			if err != nil {
+				return Token{}, err
+			}
+
+			value, err := r.readString()
+			if err != nil {
+				return Token{}, err
+			}
+
+			r.valueSeen()
+			return Token{Type: TT_STRING_VALUE, Value: value}, nil
+		case b == 't':
+			return r.readLiteral(TT_TRUE_VALUE, tRUE_BYTES)
+		case b == 'f':
+			return r.readLiteral(TT_FALSE_VALUE, fALSE_BYTES)
+		case b == 'n':
+			return r.readLiteral(TT_NULL_VALUE, nULL_BYTES)
+		case b == '-' || (b >= '0' && b <= '9'):
+			return r.readNumber(b)
+		default:
+			return Token{}, r.errorf("unexpected character %q", b)
+		}
+	}
+}
+
+func (r *TokenReader) checkDepth() error {
+	if r.limits.MaxDepth > 0 && len(r.stateStack) > r.limits.MaxDepth {
+		return &MaxDepthError{Limit: r.limits.MaxDepth, Path: r.path.String()}
+	}
+
+	return nil
+}
+
+// skipMember skips the value of a member whose key has just been read.
+func (r *TokenReader) skipMember() error {
+	depth := 0
+	for {
+		token, err := r.readToken()
+		if err != nil {
+			return err
+		}
+
+		switch token.Type {
+		case TT_OBJECT_START, TT_ARRAY_START:
+			depth++
+		case TT_OBJECT_END, TT_ARRAY_END:
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *TokenReader) readByte() (byte, error) {
+	b, err := r.rd.ReadByte()
+	if err != nil {
+		return b, err
+	}
+
+	if r.limits.MaxTotalBytes > 0 && r.pos.offset >= r.limits.MaxTotalBytes {
+		return b, &MaxTotalBytesError{Limit: r.limits.MaxTotalBytes, Path: r.path.String()}
+	}
+
+	r.lastPos = r.pos
+	r.pos.offset++
+	if b == '\n' {
+		r.pos.line++
+		r.pos.column = 1
+	} else {
+		r.pos.column++
+	}
+
+	return b, nil
+}
+
+func (r *TokenReader) peekByte() (byte, error) {
+	bs, err := r.rd.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	return bs[0], nil
+}
+
+func (r *TokenReader) skipWhitespace() (byte, error) {
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+			return b, nil
+		}
+	}
+}
+
+// checkDelimiter ensures that a number or literal is not directly followed by garbage like in 'truex' or '01'.
+func (r *TokenReader) checkDelimiter(tokenType TokenType) error {
+	b, err := r.peekByte()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case ' ', '\t', '\n', '\r', ',', ':', ']', '}':
+		return nil
+	case '{', '[', '"':
+		// concatenated top-level values need no whitespace between them
+		if r.multiDocument && r.stateStack.Peek() == TRS_INITIAL {
+			return nil
+		}
+		return r.syntaxError(r.pos, "unexpected character %q after %s", b, tokenType.Name())
+	default:
+		return r.syntaxError(r.pos, "unexpected character %q after %s", b, tokenType.Name())
+	}
+}
+
+func (r *TokenReader) readLiteral(tokenType TokenType, literal []byte) (Token, error) {
+	err := r.beginValue(tokenType)
+	if err != nil {
+		return Token{}, err
+	}
+
+	for _, expected := range literal[1:] {
+		b, err := r.readByte()
+		if err == io.EOF {
+			return Token{}, r.eofErrorf("unexpected end of input in %s", tokenType.Name())
+		}
+		if err != nil {
+			return Token{}, err
+		}
+
+		if b != expected {
+			return Token{}, r.errorf("unexpected character %q in %s", b, tokenType.Name())
+		}
+	}
+
+	err = r.checkDelimiter(tokenType)
+	if err != nil {
+		return Token{}, err
+	}
+
+	r.valueSeen()
+	return Token{Type: tokenType, Value: ""}, nil
+}
+
+// readNumber reads a number as defined by RFC 8259 and returns it with its literal text
+// so no precision is lost. Numbers without fraction and exponent are reported as TT_INTEGER_VALUE.
+func (r *TokenReader) readNumber(first byte) (Token, error) {
+	err := r.beginValue(TT_NUMBER_VALUE)
+	if err != nil {
+		return Token{}, err
+	}
+
+	r.buf = append(r.buf[:0], first)
+	if first == '-' {
+		err := r.readDigit()
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	if r.buf[len(r.buf)-1] != '0' {
+		err := r.readOptionalDigits()
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	tokenType := TT_INTEGER_VALUE
+	accepted, err := r.acceptByte('.')
+	if err != nil {
+		return Token{}, err
+	}
+	if accepted {
+		tokenType = TT_NUMBER_VALUE
+		err := r.readDigits()
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	accepted, err = r.acceptByte('e', 'E')
+	if err != nil {
+		return Token{}, err
+	}
+	if accepted {
+		tokenType = TT_NUMBER_VALUE
+		_, err := r.acceptByte('+', '-')
+		if err != nil {
+			return Token{}, err
+		}
+
+		err = r.readDigits()
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	err = r.checkDelimiter(tokenType)
+	if err != nil {
+		return Token{}, err
+	}
+
+	r.valueSeen()
+	return Token{Type: tokenType, Value: string(r.buf)}, nil
+}
+
+// acceptByte consumes the next byte of a number if it is one of the given bytes.
+func (r *TokenReader) acceptByte(accepted ...byte) (bool, error) {
+	b, err := r.peekByte()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, a := range accepted {
+		if a == b {
+			_, _ = r.readByte()
+			r.buf = append(r.buf, b)
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// readDigit reads a single mandatory digit of a number.
+func (r *TokenReader) readDigit() error {
+	b, err := r.readByte()
+	if err == io.EOF {
+		return r.eofErrorf("unexpected end of input in number")
+	}
+	if err != nil {
+		return err
+	}
+
+	if b < '0' || b > '9' {
+		return r.errorf("unexpected character %q in number", b)
+	}
+
+	r.buf = append(r.buf, b)
+	return nil
+}
+
+// readDigits reads at least one digit of a number.
+func (r *TokenReader) readDigits() error {
+	err := r.readDigit()
+	if err != nil {
+		return err
+	}
+
+	return r.readOptionalDigits()
+}
+
+func (r *TokenReader) readOptionalDigits() error {
+	for {
+		accepted, err := r.acceptByte('0', '1', '2', '3', '4', '5', '6', '7', '8', '9')
+		if err != nil || !accepted {
+			return err
+		}
+	}
+}
+
+// readString reads the remainder of a string after the opening quote and returns it unescaped.
+// Invalid UTF-8 is replaced by U+FFFD.
+func (r *TokenReader) readString() (string, error) {
+	r.buf = r.buf[:0]
+	for {
+		b, err := r.readByte()
+		if err == io.EOF {
+			return "", r.eofErrorf("unexpected end of input in string")
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case b == '"':
+			if !utf8.Valid(r.buf) {
+				return string(bytes.ToValidUTF8(r.buf, []byte("\ufffd"))), nil
+			}
+			return string(r.buf), nil
+		case b == '\\':
+			err := r.readEscape()
+			if err != nil {
+				return "", err
+			}
+		case b < 0x20:
+			return "", r.errorf("unexpected control character %q in string", b)
+		default:
+			r.buf = append(r.buf, b)
+		}
+
+		if r.limits.MaxStringLength > 0 && len(r.buf) > r.limits.MaxStringLength {
+			return "", &MaxStringLengthError{Limit: r.limits.MaxStringLength, Path: r.path.String()}
+		}
+	}
+}
+
+func (r *TokenReader) readEscape() error {
+	b, err := r.readByte()
+	if err == io.EOF {
+		return r.eofErrorf("unexpected end of input in string")
+	}
+	if err != nil {
+		return err
+	}
+
+	switch b {
+	case '"', '\\', '/':
+		r.buf = append(r.buf, b)
+	case 'b':
+		r.buf = append(r.buf, '\b')
+	case 'f':
+		r.buf = append(r.buf, '\f')
+	case 'n':
+		r.buf = append(r.buf, '\n')
+	case 'r':
+		r.buf = append(r.buf, '\r')
+	case 't':
+		r.buf = append(r.buf, '\t')
+	case 'u':
+		r1, err := r.readHex4()
+		if err != nil {
+			return err
+		}
+
+		if utf16.IsSurrogate(r1) {
+			r1 = r.readLowSurrogate(r1)
+		}
+		r.buf = appendRune(r.buf, r1)
+	default:
+		return r.errorf("invalid escape sequence '\\%c' in string", b)
+	}
+
+	return nil
+}
+
+// readLowSurrogate combines the high surrogate r1 with a directly following \uXXXX low surrogate.
+// Lone surrogates are replaced by U+FFFD.
+func (r *TokenReader) readLowSurrogate(r1 rune) rune {
+	next, err := r.rd.Peek(6)
+	if err != nil || next[0] != '\\' || next[1] != 'u' {
+		return utf8.RuneError
+	}
+
+	r2, ok := decodeHex4(next[2:])
+	if !ok {
+		return utf8.RuneError
+	}
+
+	combined := utf16.DecodeRune(r1, r2)
+	if combined == utf8.RuneError {
+		return utf8.RuneError
+	}
+
+	for i := 0; i < 6; i++ {
+		_, _ = r.readByte()
+	}
+	return combined
+}
+
+func (r *TokenReader) readHex4() (rune, error) {
+	var hex [4]byte
+	for i := range hex {
+		b, err := r.readByte()
+		if err == io.EOF {
+			return 0, r.eofErrorf("unexpected end of input in string")
+		}
+		if err != nil {
+			return 0, err
+		}
+		hex[i] = b
+	}
+
+	value, ok := decodeHex4(hex[:])
+	if !ok {
+		return 0, r.errorf("invalid unicode escape '\\u%s' in string", hex[:])
+	}
+
+	return value, nil
+}
+
+func decodeHex4(hex []byte) (rune, bool) {
+	var value rune
+	for _, b := range hex[:4] {
+		switch {
+		case b >= '0' && b <= '9':
+			b = b - '0'
+		case b >= 'a' && b <= 'f':
+			b = b - 'a' + 10
+		case b >= 'A' && b <= 'F':
+			b = b - 'A' + 10
+		default:
+			return 0, false
+		}
+		value = value<<4 | rune(b)
+	}
+
+	return value, true
+}
+
+func appendRune(dst []byte, r rune) []byte {
+	var encoded [utf8.UTFMax]byte
+	n := utf8.EncodeRune(encoded[:], r)
+	return append(dst, encoded[:n]...)
+}
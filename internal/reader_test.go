@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func readAllTokens(json string) ([]Token, error) {
+	return readTokens(NewTokenReader(strings.NewReader(json)))
+}
+
+func readTokens(rd *TokenReader) ([]Token, error) {
+	tokens := []Token{}
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return tokens, err
+		}
+
+		tokens = append(tokens, token)
+	}
+}
+
+func testReadsTokens(t *testing.T, json string, expectedTokens ...Token) {
+	tokens, err := readAllTokens(json)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	assert.Equal(t, expectedTokens, tokens)
+}
+
+func testFailsReading(t *testing.T, json string, expectedMsg string) {
+	_, err := readAllTokens(json)
+
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected syntax error, got %v", err)
+		return
+	}
+	assert.Equal(t, expectedMsg, syntaxErr.Msg)
+}
+
+func TestReadsString(t *testing.T) {
+	testReadsTokens(t, "\"value\"", Token{Type: TT_STRING_VALUE, Value: "value"})
+}
+
+func TestReadsLiterals(t *testing.T) {
+	testReadsTokens(t, "[true, false, null]", Token{Type: TT_ARRAY_START, Value: ""},
+		Token{Type: TT_TRUE_VALUE, Value: ""}, Token{Type: TT_FALSE_VALUE, Value: ""}, Token{Type: TT_NULL_VALUE, Value: ""},
+		Token{Type: TT_ARRAY_END, Value: ""})
+}
+
+func TestReadsNumbersWithLiteralText(t *testing.T) {
+	testReadsTokens(t, "[0,-12,1.50,1e3,-0.5E-2,12345678901234567890]", Token{Type: TT_ARRAY_START, Value: ""},
+		Token{Type: TT_INTEGER_VALUE, Value: "0"}, Token{Type: TT_INTEGER_VALUE, Value: "-12"}, Token{Type: TT_NUMBER_VALUE, Value: "1.50"},
+		Token{Type: TT_NUMBER_VALUE, Value: "1e3"}, Token{Type: TT_NUMBER_VALUE, Value: "-0.5E-2"}, Token{Type: TT_INTEGER_VALUE, Value: "12345678901234567890"},
+		Token{Type: TT_ARRAY_END, Value: ""})
+}
+
+func TestReadsTopLevelNumberAtEndOfInput(t *testing.T) {
+	testReadsTokens(t, "42", Token{Type: TT_INTEGER_VALUE, Value: "42"})
+}
+
+func TestReadsNestedObject(t *testing.T) {
+	testReadsTokens(t, " {\n\t\"key\": {\"inner\": [1, {}]},\r\n\"key2\": \"value2\"\n} ",
+		Token{Type: TT_OBJECT_START, Value: ""}, Token{Type: TT_KEY, Value: "key"},
+		Token{Type: TT_OBJECT_START, Value: ""}, Token{Type: TT_KEY, Value: "inner"},
+		Token{Type: TT_ARRAY_START, Value: ""}, Token{Type: TT_INTEGER_VALUE, Value: "1"}, Token{Type: TT_OBJECT_START, Value: ""}, Token{Type: TT_OBJECT_END, Value: ""}, Token{Type: TT_ARRAY_END, Value: ""},
+		Token{Type: TT_OBJECT_END, Value: ""},
+		Token{Type: TT_KEY, Value: "key2"}, Token{Type: TT_STRING_VALUE, Value: "value2"},
+		Token{Type: TT_OBJECT_END, Value: ""})
+}
+
+func TestUnescapesStrings(t *testing.T) {
+	testReadsTokens(t, `{"k\"ey":"\\\/\b\f\n\r\t\u00e4\u20AC\ud83d\ude00"}`,
+		Token{Type: TT_OBJECT_START, Value: ""}, Token{Type: TT_KEY, Value: "k\"ey"},
+		Token{Type: TT_STRING_VALUE, Value: "\\/\b\f\n\r\tä€😀"}, Token{Type: TT_OBJECT_END, Value: ""})
+}
+
+func TestReplacesLoneSurrogatesAndInvalidUTF8(t *testing.T) {
+	testReadsTokens(t, "[\"\\ud83dx\",\"a\xffb\"]", Token{Type: TT_ARRAY_START, Value: ""},
+		Token{Type: TT_STRING_VALUE, Value: "\ufffdx"}, Token{Type: TT_STRING_VALUE, Value: "a\ufffdb"},
+		Token{Type: TT_ARRAY_END, Value: ""})
+}
+
+func TestFailsOnUnclosedArray(t *testing.T) {
+	testFailsReading(t, "[1,2", "unexpected end of input in TRS_IN_ARRAY_ITEM_SEEN")
+}
+
+func TestFailsOnTrailingComma(t *testing.T) {
+	testFailsReading(t, "[1,]", "TT_ARRAY_END not allowed in TRS_IN_ARRAY_COMMA_SEEN")
+}
+
+func TestFailsOnMissingColon(t *testing.T) {
+	testFailsReading(t, "{\"key\" 1}", "TT_NUMBER_VALUE not allowed in TRS_IN_OBJECT_KEY_SEEN")
+}
+
+func TestFailsOnDataAfterTopLevelValue(t *testing.T) {
+	testFailsReading(t, "{} []", "unexpected character '[' after top-level value")
+}
+
+func TestFailsOnInvalidNumbers(t *testing.T) {
+	testFailsReading(t, "01", "unexpected character '1' after TT_INTEGER_VALUE")
+	testFailsReading(t, "-", "unexpected end of input in number")
+	testFailsReading(t, "1.", "unexpected end of input in number")
+	testFailsReading(t, "1.e5", "unexpected character 'e' in number")
+	testFailsReading(t, "[1e+]", "unexpected character ']' in number")
+}
+
+func TestFailsOnInvalidLiteral(t *testing.T) {
+	testFailsReading(t, "[tru]", "unexpected character ']' in TT_TRUE_VALUE")
+	testFailsReading(t, "nullx", "unexpected character 'x' after TT_NULL_VALUE")
+}
+
+func TestFailsOnControlCharacterInString(t *testing.T) {
+	testFailsReading(t, "\"a\nb\"", "unexpected control character '\\n' in string")
+}
+
+func TestFailsOnInvalidEscape(t *testing.T) {
+	testFailsReading(t, "\"\\x\"", "invalid escape sequence '\\x' in string")
+	testFailsReading(t, "\"\\u12g4\"", "invalid unicode escape '\\u12g4' in string")
+}
+
+func TestReadsWhatWasWritten(t *testing.T) {
+	tokens := []Token{{Type: TT_ARRAY_START, Value: ""}, {Type: TT_STRING_VALUE, Value: "a\"\\\x01ä"},
+		{Type: TT_OBJECT_START, Value: ""}, {Type: TT_KEY, Value: "k\ney"}, {Type: TT_NULL_VALUE, Value: ""}, {Type: TT_OBJECT_END, Value: ""},
+		{Type: TT_ARRAY_END, Value: ""}}
+
+	buf := new(strings.Builder)
+	wr := NewTokenWriter(buf)
+	err := wr.WriteTokens(tokens...)
+	if err != nil {
+		t.Fatal(err)
+		return
+	}
+
+	testReadsTokens(t, buf.String(), tokens...)
+}
+
+func TestReportsPositionAndPathOfSyntaxError(t *testing.T) {
+	_, err := readAllTokens("{\"items\": [\n  {\"name\": \"a\"},\n  {\"name\": tru}\n]}")
+
+	assert.Equal(t, &SyntaxError{Msg: "unexpected character '}' in TT_TRUE_VALUE", Offset: 43, Line: 3, Column: 15, Path: "$.items[1].name"}, err)
+	assert.EqualError(t, err, "unexpected character '}' in TT_TRUE_VALUE at line 3, column 15 (offset 43, path $.items[1].name)")
+}
+
+func TestReportsPositionOfUnexpectedEndOfInput(t *testing.T) {
+	_, err := readAllTokens("[\"a\",\n\"b")
+
+	assert.Equal(t, &SyntaxError{Msg: "unexpected end of input in string", Offset: 8, Line: 2, Column: 3, Path: "$[1]"}, err)
+}
+
+func TestQuotesNonIdentifierKeysInPath(t *testing.T) {
+	_, err := readAllTokens("{\"a b\": {\"x\": [}}")
+
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(err, &syntaxErr))
+	assert.Equal(t, "$[\"a b\"].x", syntaxErr.Path)
+}
+
+func TestTracksTokenOffsets(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader(" {\"key\": [12, \"v\"]}"))
+	rd.SetTrackOffsets(true)
+
+	tokens := []Token{}
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+			return
+		}
+		tokens = append(tokens, token)
+	}
+
+	assert.Equal(t, []Token{{Type: TT_OBJECT_START, Value: "", Start: 1, End: 2}, {Type: TT_KEY, Value: "key", Start: 2, End: 7},
+		{Type: TT_ARRAY_START, Value: "", Start: 9, End: 10}, {Type: TT_INTEGER_VALUE, Value: "12", Start: 10, End: 12},
+		{Type: TT_STRING_VALUE, Value: "v", Start: 14, End: 17}, {Type: TT_ARRAY_END, Value: "", Start: 17, End: 18},
+		{Type: TT_OBJECT_END, Value: "", Start: 18, End: 19}}, tokens)
+}
+
+func TestFailsReadingDuplicateKey(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("{\"a\":{\"b\":1,\"c\":2,\"b\":3}}"))
+	rd.SetDuplicateKeyPolicy(DKP_ERROR)
+
+	var err error
+	for err == nil {
+		_, err = rd.ReadToken()
+	}
+
+	var duplicateKeyErr *DuplicateKeyError
+	assert.True(t, errors.As(err, &duplicateKeyErr))
+	assert.Equal(t, "b", duplicateKeyErr.Key)
+	assert.EqualError(t, err, "duplicate key \"b\" at $.a.b")
+}
+
+func TestSkipsReadMembersWithDuplicateKey(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("{\"a\":1,\"a\":{\"b\":[2]},\"c\":{\"a\":3}}"))
+	rd.SetDuplicateKeyPolicy(DKP_DROP)
+
+	var v map[string]interface{}
+	assert.NoError(t, rd.Decode(&v))
+	assert.Equal(t, map[string]interface{}{"a": 1.0, "c": map[string]interface{}{"a": 3.0}}, v)
+}
+
+func TestDecodesLastValueOfDuplicateKeyByDefault(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("{\"a\":1,\"a\":2}"))
+
+	var v map[string]int
+	assert.NoError(t, rd.Decode(&v))
+	assert.Equal(t, map[string]int{"a": 2}, v)
+}
+
+func TestChecksOnlyTrackedKeysOnRead(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("{\"a\":1,\"b\":2,\"b\":3,\"a\":4}"))
+	rd.SetDuplicateKeyPolicy(DKP_ERROR)
+	rd.SetMaxTrackedKeys(1)
+
+	var err error
+	for err == nil {
+		_, err = rd.ReadToken()
+	}
+	assert.EqualError(t, err, "duplicate key \"a\" at $.a")
+}
+
+func readAllTokensWithLimits(json string, limits Limits) error {
+	rd := NewTokenReader(strings.NewReader(json))
+	rd.SetLimits(limits)
+	for {
+		_, err := rd.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func TestFailsReadingTooDeeplyNestedInput(t *testing.T) {
+	err := readAllTokensWithLimits("{\"a\":[[1]]}", Limits{MaxDepth: 2})
+
+	var depthErr *MaxDepthError
+	assert.True(t, errors.As(err, &depthErr))
+	assert.EqualError(t, err, "maximum depth of 2 exceeded at $.a[0]")
+	assert.NoError(t, readAllTokensWithLimits("{\"a\":[1]}", Limits{MaxDepth: 2}))
+}
+
+func TestFailsReadingTooLongString(t *testing.T) {
+	err := readAllTokensWithLimits("[\"abc\",\"a\\u00e4bc\"]", Limits{MaxStringLength: 4})
+
+	var lengthErr *MaxStringLengthError
+	assert.True(t, errors.As(err, &lengthErr))
+	assert.EqualError(t, err, "maximum string length of 4 exceeded at $[1]")
+}
+
+func TestFailsReadingObjectWithTooManyKeys(t *testing.T) {
+	err := readAllTokensWithLimits("{\"a\":{\"b\":1,\"c\":2},\"d\":3}", Limits{MaxKeysPerObject: 1})
+
+	var keysErr *MaxKeysPerObjectError
+	assert.True(t, errors.As(err, &keysErr))
+	assert.EqualError(t, err, "maximum number of 1 keys per object exceeded at $.a.c")
+}
+
+func TestFailsReadingTooLargeInput(t *testing.T) {
+	err := readAllTokensWithLimits("[1, 2, 3]", Limits{MaxTotalBytes: 8})
+
+	var sizeErr *MaxTotalBytesError
+	assert.True(t, errors.As(err, &sizeErr))
+	assert.EqualError(t, err, "maximum size of 8 bytes exceeded at $[2]")
+	assert.NoError(t, readAllTokensWithLimits("[1, 2, 3]", Limits{MaxTotalBytes: 9}))
+}
+
+func TestReadsConcatenatedDocuments(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("{\"a\":1}{\"b\":2} 3\n[true]\"x\"null"))
+	rd.SetMultiDocument(true)
+
+	tokens := []TokenType{}
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		tokens = append(tokens, token.Type)
+	}
+
+	assert.Equal(t, []TokenType{
+		TT_OBJECT_START, TT_KEY, TT_INTEGER_VALUE, TT_OBJECT_END, TT_DOCUMENT_END,
+		TT_OBJECT_START, TT_KEY, TT_INTEGER_VALUE, TT_OBJECT_END, TT_DOCUMENT_END,
+		TT_INTEGER_VALUE, TT_DOCUMENT_END,
+		TT_ARRAY_START, TT_TRUE_VALUE, TT_ARRAY_END, TT_DOCUMENT_END,
+		TT_STRING_VALUE, TT_DOCUMENT_END,
+		TT_NULL_VALUE, TT_DOCUMENT_END,
+	}, tokens)
+}
+
+func TestDecodesConcatenatedDocuments(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("{\"a\":1}{\"a\":2}"))
+	rd.SetMultiDocument(true)
+
+	values := []map[string]int{}
+	for {
+		var value map[string]int
+		err := rd.Decode(&value)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		values = append(values, value)
+	}
+
+	assert.Equal(t, []map[string]int{{"a": 1}, {"a": 2}}, values)
+}
+
+func TestFailsOnIncompleteLastDocument(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("[1][2"))
+	rd.SetMultiDocument(true)
+
+	_, err := readTokens(rd)
+
+	assert.EqualError(t, err, "unexpected end of input in TRS_IN_ARRAY_ITEM_SEEN at line 1, column 6 (offset 5, path $[0])")
+}
+
+func TestFailsOnUndelimitedDocuments(t *testing.T) {
+	rd := NewTokenReader(strings.NewReader("truefalse"))
+	rd.SetMultiDocument(true)
+
+	_, err := readTokens(rd)
+
+	assert.Error(t, err)
+}
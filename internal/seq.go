@@ -0,0 +1,263 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// RECORD_SEPARATOR starts every record of a json text sequence (RFC 7464).
+const RECORD_SEPARATOR byte = 0x1E
+
+// SeqReader reads a json text sequence according to RFC 7464 record by record. A malformed
+// record does not fail the stream: Next skips its remaining bytes up to the next record
+// separator and counts them in SkippedBytes.
+type SeqReader struct {
+	rd     *bufio.Reader
+	record *TokenReader
+	// failed is set once an error has been returned for the current record
+	failed bool
+	// offset counts the bytes consumed from rd, recordOffset is the offset of the current record
+	offset       int64
+	recordOffset int64
+	skipped      int64
+	// inRecord is set while the bytes up to the next record separator belong to the current record
+	inRecord           bool
+	duplicateKeyPolicy DuplicateKeyPolicy
+	limits             Limits
+}
+
+func NewSeqReader(rd io.Reader) *SeqReader {
+	return &SeqReader{rd: bufio.NewReader(rd)}
+}
+
+// SetDuplicateKeyPolicy sets the duplicate key policy for every record.
+func (s *SeqReader) SetDuplicateKeyPolicy(policy DuplicateKeyPolicy) {
+	s.duplicateKeyPolicy = policy
+}
+
+// SetLimits sets the limits checked for every record.
+func (s *SeqReader) SetLimits(limits Limits) {
+	s.limits = limits
+}
+
+// SkippedBytes returns the number of bytes skipped so far, because they belonged to malformed
+// or not completely read records or preceded the first record separator.
+func (s *SeqReader) SkippedBytes() int64 {
+	return s.skipped
+}
+
+// RecordOffset returns the byte offset of the current record in the stream. Offsets of
+// errors and tokens are relative to it.
+func (s *SeqReader) RecordOffset() int64 {
+	return s.recordOffset
+}
+
+// Next advances to the next non empty record, skipping what is left of the current one.
+// io.EOF is returned after the last record.
+func (s *SeqReader) Next() error {
+	if s.record != nil {
+		err := s.finishRecord()
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		err := s.skipToRecord()
+		if err != nil {
+			return err
+		}
+
+		empty, err := s.skipRecordWhitespace()
+		if err != nil {
+			return err
+		}
+		if !empty {
+			break
+		}
+	}
+
+	s.record = NewTokenReader(seqRecordReader{s})
+	s.record.SetDuplicateKeyPolicy(s.duplicateKeyPolicy)
+	s.record.SetLimits(s.limits)
+	s.failed = false
+	return nil
+}
+
+// ReadToken returns the next token of the current record, io.EOF after its value has been read completely.
+func (s *SeqReader) ReadToken() (Token, error) {
+	if s.record == nil {
+		return Token{}, fmt.Errorf("no current record, Next must be called first")
+	}
+
+	initial := s.record.callerState() == TRS_INITIAL
+	token, err := s.record.ReadToken()
+	if err == nil && initial {
+		err = s.checkTruncated(token.Type)
+	}
+
+	return token, s.recordErr(err)
+}
+
+// PeekToken returns the next token of the current record without consuming it.
+func (s *SeqReader) PeekToken() (Token, error) {
+	if s.record == nil {
+		return Token{}, fmt.Errorf("no current record, Next must be called first")
+	}
+
+	token, err := s.record.PeekToken()
+	return token, s.recordErr(err)
+}
+
+// Decode decodes the value of the current record into v like TokenReader.Decode
+// and checks that the record contains nothing else.
+func (s *SeqReader) Decode(v interface{}) error {
+	token, err := s.PeekToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.record.Decode(v)
+	if err == nil {
+		err = s.checkTruncated(token.Type)
+	}
+	if err == nil {
+		_, err = s.record.ReadToken()
+		if err == io.EOF {
+			return nil
+		}
+	}
+
+	return s.recordErr(err)
+}
+
+// Path returns the structural position of the last token read from the current record.
+func (s *SeqReader) Path() string {
+	if s.record == nil {
+		return "$"
+	}
+
+	return s.record.Path()
+}
+
+func (s *SeqReader) recordErr(err error) error {
+	if err != nil && err != io.EOF {
+		s.failed = true
+	}
+
+	return err
+}
+
+// checkTruncated fails a record with a top-level number or literal not followed by whitespace,
+// as required by RFC 7464 to detect truncated records.
+func (s *SeqReader) checkTruncated(tokenType TokenType) error {
+	switch tokenType {
+	case TT_NUMBER_VALUE, TT_INTEGER_VALUE, TT_TRUE_VALUE, TT_FALSE_VALUE, TT_NULL_VALUE:
+	default:
+		return nil
+	}
+
+	_, err := s.record.peekByte()
+	if err == io.EOF {
+		return s.record.eofErrorf("truncated record, %s not followed by whitespace", tokenType.Name())
+	}
+
+	return err
+}
+
+// finishRecord discards what is left of the current record, counting it as skipped unless
+// the record has been read completely.
+func (s *SeqReader) finishRecord() error {
+	valid := !s.failed && s.record.callerState() == TRS_END
+	if valid {
+		_, err := s.record.ReadToken()
+		valid = err == io.EOF
+	}
+
+	_, err := io.Copy(ioutil.Discard, s.record.rd)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		s.skipped += s.offset - s.recordOffset
+	}
+	s.record = nil
+	return nil
+}
+
+// skipToRecord consumes the input up to and including the next record separator,
+// bytes before it are counted as skipped.
+func (s *SeqReader) skipToRecord() error {
+	for {
+		b, err := s.rd.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		s.offset++
+		if b == RECORD_SEPARATOR {
+			s.inRecord = true
+			return nil
+		}
+		s.skipped++
+	}
+}
+
+// skipRecordWhitespace consumes leading whitespace of a record and reports if the record is empty.
+func (s *SeqReader) skipRecordWhitespace() (bool, error) {
+	for {
+		bs, err := s.rd.Peek(1)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		switch bs[0] {
+		case ' ', '\t', '\n', '\r':
+			_, _ = s.rd.ReadByte()
+			s.offset++
+		case RECORD_SEPARATOR:
+			return true, nil
+		default:
+			s.recordOffset = s.offset
+			return false, nil
+		}
+	}
+}
+
+// seqRecordReader reads the bytes of the current record, it ends before the next record separator.
+type seqRecordReader struct {
+	s *SeqReader
+}
+
+func (r seqRecordReader) Read(p []byte) (int, error) {
+	s := r.s
+	if !s.inRecord || len(p) == 0 {
+		return 0, io.EOF
+	}
+
+	_, err := s.rd.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+
+	buffered, _ := s.rd.Peek(s.rd.Buffered())
+	if i := bytes.IndexByte(buffered, RECORD_SEPARATOR); i != -1 {
+		buffered = buffered[:i]
+		if i == 0 {
+			s.inRecord = false
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, buffered)
+	_, _ = s.rd.Discard(n)
+	s.offset += int64(n)
+	return n, nil
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func decodeAllRecords(seq string) ([]interface{}, []error, *SeqReader) {
+	rd := NewSeqReader(strings.NewReader(seq))
+	values := []interface{}{}
+	errs := []error{}
+	for {
+		err := rd.Next()
+		if err == io.EOF {
+			return values, errs, rd
+		}
+		if err != nil {
+			return values, append(errs, err), rd
+		}
+
+		var value interface{}
+		err = rd.Decode(&value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		values = append(values, value)
+	}
+}
+
+func TestReadsJSONTextSequence(t *testing.T) {
+	values, errs, rd := decodeAllRecords("\x1e{\"a\":1}\n\x1e\"b\"\n\x1e\n\x1e[true]\n")
+
+	assert.Empty(t, errs)
+	assert.Equal(t, []interface{}{map[string]interface{}{"a": 1.0}, "b", []interface{}{true}}, values)
+	assert.Equal(t, int64(0), rd.SkippedBytes())
+}
+
+func TestSkipsMalformedRecords(t *testing.T) {
+	values, errs, rd := decodeAllRecords("\x1e{\"a\":\n\x1e{\"b\":2}\n\x1e[1]x\n\x1e3\n")
+
+	assert.Len(t, errs, 2)
+	var syntaxErr *SyntaxError
+	assert.True(t, errors.As(errs[0], &syntaxErr))
+	assert.Equal(t, []interface{}{map[string]interface{}{"b": 2.0}, 3.0}, values)
+	assert.Equal(t, int64(len("{\"a\":\n")+len("[1]x\n")), rd.SkippedBytes())
+}
+
+func TestRejectsTruncatedRecord(t *testing.T) {
+	values, errs, rd := decodeAllRecords("\x1e12\x1etrue\n")
+
+	assert.Len(t, errs, 1)
+	assert.EqualError(t, errs[0], "truncated record, TT_INTEGER_VALUE not followed by whitespace at line 1, column 3 (offset 2, path $)")
+	assert.Equal(t, []interface{}{true}, values)
+	assert.Equal(t, int64(2), rd.SkippedBytes())
+}
+
+func TestSkipsBytesBeforeFirstRecord(t *testing.T) {
+	values, errs, rd := decodeAllRecords("garbage\x1enull\n")
+
+	assert.Empty(t, errs)
+	assert.Equal(t, []interface{}{nil}, values)
+	assert.Equal(t, int64(7), rd.SkippedBytes())
+}
+
+func TestReadsRecordTokens(t *testing.T) {
+	rd := NewSeqReader(strings.NewReader("\x1e[1,2]\n\x1e{}\n"))
+
+	assert.NoError(t, rd.Next())
+	token, err := rd.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, TT_ARRAY_START, token.Type)
+
+	assert.NoError(t, rd.Next())
+	assert.Equal(t, int64(8), rd.RecordOffset())
+	token, err = rd.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, TT_OBJECT_START, token.Type)
+	token, err = rd.ReadToken()
+	assert.NoError(t, err)
+	assert.Equal(t, TT_OBJECT_END, token.Type)
+	_, err = rd.ReadToken()
+	assert.Equal(t, io.EOF, err)
+
+	assert.Equal(t, io.EOF, rd.Next())
+	assert.Equal(t, int64(len("[1,2]\n")), rd.SkippedBytes())
+}
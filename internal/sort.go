@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// objectSorter buffers the members of objects as token sub-streams and writes them ordered
+// by key when the outermost buffered object ends. Tokens are checked on arrival by a shadow
+// TokenWriter writing to nowhere, so errors are reported for the offending token.
+type objectSorter struct {
+	shadow *TokenWriter
+	less   func(a string, b string) bool
+	frames []*sortFrame
+	// size is the estimated memory used by the buffered tokens
+	size int
+	// stopping is set if sorting has been disabled while an object is buffered
+	stopping bool
+}
+
+// SORT_TOKEN_OVERHEAD is the memory estimated per buffered token in addition to its value.
+const SORT_TOKEN_OVERHEAD = 48
+
+// SortBufferError is returned if an object is too large to be buffered for sorting.
+type SortBufferError struct {
+	Limit int
+}
+
+func (e *SortBufferError) Error() string {
+	return fmt.Sprintf("object too large to sort, maximum buffer size of %d bytes exceeded", e.Limit)
+}
+
+type sortFrame struct {
+	members []sortMember
+}
+
+type sortMember struct {
+	key    string
+	tokens []Token
+}
+
+func newObjectSorter(t *TokenWriter, less func(a string, b string) bool) *objectSorter {
+	shadow := NewTokenWriter(ioutil.Discard)
+	shadow.stateStack = append(tokenWriterStateStack{}, t.stateStack...)
+	shadow.path = append(pathStack{}, t.path...)
+	for range t.keys.counts {
+		shadow.keys.Push()
+	}
+
+	return &objectSorter{shadow: shadow, less: less}
+}
+
+// writeToken checks token and buffers it, or writes it to t if no object is open.
+func (s *objectSorter) writeToken(t *TokenWriter, token Token) error {
+	s.shadow.duplicateKeyPolicy = t.duplicateKeyPolicy
+	s.shadow.keys.maxKeys = t.keys.maxKeys
+	s.shadow.limits = t.limits
+	s.shadow.limits.MaxTotalBytes = 0
+	s.shadow.escape.invalidUTF8Policy = t.escape.invalidUTF8Policy
+	s.shadow.multiDocument = t.multiDocument
+	s.shadow.completing = t.completing
+
+	// the cap is checked before the shadow advances, so that a rejected token leaves no trace
+	buffered := token.Type == TT_OBJECT_START || len(s.frames) > 0
+	size := s.size + len(token.Value) + SORT_TOKEN_OVERHEAD
+	if buffered && t.maxSortBufferSize > 0 && size > t.maxSortBufferSize && !t.completing {
+		return &SortBufferError{Limit: t.maxSortBufferSize}
+	}
+
+	err := s.shadow.writeToken(token)
+	if err != nil {
+		return err
+	}
+
+	if !buffered {
+		return t.writeToken(token)
+	}
+	s.size = size
+
+	if token.Type == TT_OBJECT_START {
+		s.frames = append(s.frames, &sortFrame{})
+		return nil
+	}
+
+	frame := s.frames[len(s.frames)-1]
+	switch token.Type {
+	case TT_COLON, TT_COMMA:
+		// separators are added again when the members are written
+	case TT_KEY:
+		frame.members = append(frame.members, sortMember{key: token.Value})
+	case TT_OBJECT_END:
+		s.frames = s.frames[:len(s.frames)-1]
+		sort.SliceStable(frame.members, func(i, j int) bool {
+			return s.less(frame.members[i].key, frame.members[j].key)
+		})
+
+		if len(s.frames) == 0 {
+			return s.writeObject(t, frame)
+		}
+		parent := s.frames[len(s.frames)-1]
+		member := &parent.members[len(parent.members)-1]
+		member.tokens = appendObjectTokens(member.tokens, frame)
+	default:
+		member := &frame.members[len(frame.members)-1]
+		member.tokens = append(member.tokens, token)
+	}
+
+	return nil
+}
+
+func (s *objectSorter) writeObject(t *TokenWriter, frame *sortFrame) error {
+	s.size = 0
+	if s.stopping {
+		t.sorter = nil
+	}
+	for _, token := range appendObjectTokens(nil, frame) {
+		err := t.writeToken(token)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendObjectTokens(tokens []Token, frame *sortFrame) []Token {
+	tokens = append(tokens, Token{Type: TT_OBJECT_START, Value: ""})
+	for _, member := range frame.members {
+		tokens = append(tokens, Token{Type: TT_KEY, Value: member.key})
+		tokens = append(tokens, member.tokens...)
+	}
+
+	return append(tokens, Token{Type: TT_OBJECT_END, Value: ""})
+}
+
+// lessUTF16 orders strings by their UTF-16 code units as required by RFC 8785.
+func lessUTF16(a string, b string) bool {
+	for a != "" && b != "" {
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if ra != rb {
+			unitA, unitB := firstUTF16Unit(ra), firstUTF16Unit(rb)
+			if unitA != unitB {
+				return unitA < unitB
+			}
+			// same high surrogate, the low surrogates are ordered like the runes
+			return ra < rb
+		}
+
+		a, b = a[sizeA:], b[sizeB:]
+	}
+
+	return a == "" && b != ""
+}
+
+func firstUTF16Unit(r rune) rune {
+	if r >= 0x10000 {
+		high, _ := utf16.EncodeRune(r)
+		return high
+	}
+
+	return r
+}
@@ -0,0 +1,265 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"strings"
+	"testing"
+)
+
+func testWritesCanonical(t *testing.T, expectedJson string, write func(wr *TokenWriter)) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetIndent("\t")
+	wr.SetEscapeHTML(true)
+	wr.SetCanonical(true)
+
+	write(wr)
+
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, expectedJson, buf.String())
+}
+
+func TestSortsKeysByUTF16CodeUnits(t *testing.T) {
+	// example of RFC 8785, section 3.2.3
+	testWritesCanonical(t, "{\"\\r\":\"Carriage Return\",\"1\":\"One\",\"\u0080\":\"Control\",\"\u00f6\":\"Latin Small Letter O With Diaeresis\",\"\u20ac\":\"Euro Sign\",\"\U0001f600\":\"Emoji: Grinning Face\",\"\ufb33\":\"Hebrew Letter Dalet With Dagesh\"}", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKeyAndStringValue("\u20ac", "Euro Sign"))
+		assert.NoError(t, wr.WriteKeyAndStringValue("\r", "Carriage Return"))
+		assert.NoError(t, wr.WriteKeyAndStringValue("\ufb33", "Hebrew Letter Dalet With Dagesh"))
+		assert.NoError(t, wr.WriteKeyAndStringValue("1", "One"))
+		assert.NoError(t, wr.WriteKeyAndStringValue("\U0001f600", "Emoji: Grinning Face"))
+		assert.NoError(t, wr.WriteKeyAndStringValue("\u0080", "Control"))
+		assert.NoError(t, wr.WriteKeyAndStringValue("\u00f6", "Latin Small Letter O With Diaeresis"))
+		assert.NoError(t, wr.WriteObjectEnd())
+	})
+}
+
+func TestWritesCanonicalNestedStructures(t *testing.T) {
+	// example of RFC 8785, section 3.2.2
+	testWritesCanonical(t, "{\"literals\":[null,true,false],\"numbers\":[333333333.3333333,1e+30,4.5,0.002,1e-27],\"string\":\"\u20ac$\\u000f\\nA'B\\\"\\\\\\\\\\\"/\"}", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKey("numbers"))
+		assert.NoError(t, wr.WriteRawValue([]byte("[333333333.33333329, 1E30, 4.50, 2e-3, 0.000000000000000000000000001]")))
+		assert.NoError(t, wr.WriteKeyAndStringValue("string", "\u20ac$\u000f\nA'B\"\\\\\"/"))
+		assert.NoError(t, wr.WriteKey("literals"))
+		assert.NoError(t, wr.WriteValue([]interface{}{nil, true, false}))
+		assert.NoError(t, wr.WriteObjectEnd())
+	})
+}
+
+func TestSortsMembersOfObjectsInArrays(t *testing.T) {
+	testWritesCanonical(t, "[{\"a\":[{\"x\":1,\"y\":2}],\"b\":{\"c\":3,\"d\":4}},5]", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteArrayStart())
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKey("b"))
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKeyAndIntegerValue("d", 4))
+		assert.NoError(t, wr.WriteKeyAndIntegerValue("c", 3))
+		assert.NoError(t, wr.WriteObjectEnd())
+		assert.NoError(t, wr.WriteKey("a"))
+		assert.NoError(t, wr.WriteRawValue([]byte("[{\"y\":2,\"x\":1}]")))
+		assert.NoError(t, wr.WriteObjectEnd())
+		assert.NoError(t, wr.WriteIntegerValue(5))
+		assert.NoError(t, wr.WriteArrayEnd())
+	})
+}
+
+func TestFormatsCanonicalNumbers(t *testing.T) {
+	testWritesCanonical(t, "[0,9007199254740992,1e+21,123456789012345680000,0.000001,1e-7,-1.5]", func(wr *TokenWriter) {
+		assert.NoError(t, wr.WriteArrayStart())
+		assert.NoError(t, wr.WriteNumberValue(math.Copysign(0, -1)))
+		assert.NoError(t, wr.WriteUint64Value(9007199254740993))
+		assert.NoError(t, wr.WriteNumberValue(1e21))
+		assert.NoError(t, wr.WriteRawValue([]byte("123456789012345678901")))
+		assert.NoError(t, wr.WriteNumberValue(1e-6))
+		assert.NoError(t, wr.WriteNumberValue(1e-7))
+		assert.NoError(t, wr.WriteNumberValue(-1.5))
+		assert.NoError(t, wr.WriteArrayEnd())
+	})
+}
+
+func TestIgnoresFormatOptionsInCanonicalMode(t *testing.T) {
+	testWritesCanonical(t, "{\"a\":[1e+21,\"<\u2028\u20ac\"]}", func(wr *TokenWriter) {
+		wr.SetSortKeys(false)
+		wr.SetIndent("  ")
+		wr.SetFormatOptions(FormatOptions{SpaceAfterComma: true, TrailingNewline: true})
+		wr.SetEscapeHTML(true)
+		wr.SetASCIIOnly(true)
+		wr.SetNumberNotationThresholds(0, 1e30)
+		wr.SetLargeIntegersAsStrings(true)
+
+		assert.NoError(t, wr.WriteObjectStart())
+		assert.NoError(t, wr.WriteKey("a"))
+		assert.NoError(t, wr.WriteArrayStart())
+		assert.NoError(t, wr.WriteNumberValue(1e21))
+		assert.NoError(t, wr.WriteStringValue("<\u2028\u20ac"))
+		assert.NoError(t, wr.WriteArrayEnd())
+		assert.NoError(t, wr.WriteObjectEnd())
+	})
+}
+
+func TestFailsEarlyOnInvalidTokenWhileSorting(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetCanonical(true)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.EqualError(t, wr.WriteStringValue("a"), "TT_STRING_VALUE not allowed in TWS_IN_OBJECT")
+	assert.Equal(t, "", buf.String())
+}
+
+func TestReportsPathOfErrorWhileSorting(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_ERROR)
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	wr.SetSortKeys(true)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.EqualError(t, wr.WriteKey("a"), "duplicate key \"a\" at $[1].a")
+}
+
+func TestAbortWritesBufferedMembersSorted(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetCanonical(true)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("b", 1))
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.Abort(nil))
+
+	assert.Equal(t, "{\"a\":[],\"b\":1}", buf.String())
+}
+
+func TestWritesSortedKeysWithIndent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetIndent("  ")
+	wr.SetSortKeys(true)
+
+	assert.NoError(t, wr.WriteValue(map[int]interface{}{10: map[string]int{"z": 1, "y": 2}, 9: nil}))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\n  \"10\": {\n    \"y\": 2,\n    \"z\": 1\n  },\n  \"9\": null\n}", buf.String())
+}
+
+func TestSortsKeysWithComparator(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetKeyComparator(func(a string, b string) int {
+		return len(a) - len(b)
+	})
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("ccc", 3))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("bb", 2))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("d", 4))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"a\":1,\"d\":4,\"bb\":2,\"ccc\":3}", buf.String())
+}
+
+func TestFailsSortingObjectLargerThanBuffer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetSortKeys(true)
+	wr.SetMaxSortBufferSize(5 * SORT_TOKEN_OVERHEAD)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	err := wr.WriteKeyAndIntegerValue("b", 2)
+
+	var bufferErr *SortBufferError
+	assert.True(t, errors.As(err, &bufferErr))
+	assert.EqualError(t, err, "object too large to sort, maximum buffer size of 240 bytes exceeded")
+	assert.Equal(t, "[{\"a\":1}", buf.String())
+}
+
+func TestAbortCompletesObjectLargerThanBuffer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetSortKeys(true)
+	wr.SetMaxSortBufferSize(5 * SORT_TOKEN_OVERHEAD)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("b", 1))
+	assert.NoError(t, wr.WriteKey("a"))
+	err := wr.WriteIntegerValue(2)
+	var bufferErr *SortBufferError
+	assert.True(t, errors.As(err, &bufferErr))
+	assert.NoError(t, wr.Abort(nil))
+
+	assert.Equal(t, "[{\"a\":null,\"b\":1}]", buf.String())
+	assert.Equal(t, err, wr.Err())
+}
+
+func TestWritesBufferedObjectSortedAfterSortingDisabled(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetSortKeys(true)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("b", 1))
+	wr.SetSortKeys(false)
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 2))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.WriteValue(map[string]int{"d": 1}))
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("d", 1))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("c", 2))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "[{\"a\":2,\"b\":1},{\"d\":1},{\"d\":1,\"c\":2}]", buf.String())
+}
+
+func TestChangesComparatorOfBufferedObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetSortKeys(true)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	wr.SetKeyComparator(func(a string, b string) int {
+		return strings.Compare(b, a)
+	})
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("b", 2))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"b\":2,\"a\":1}", buf.String())
+}
+
+func TestKeepsCanonicalKeyOrder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetCanonical(true)
+	wr.SetSortKeys(false)
+	wr.SetKeyComparator(func(a string, b string) int {
+		return strings.Compare(b, a)
+	})
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("b", 1))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 2))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"a\":2,\"b\":1}", buf.String())
+}
@@ -16,13 +16,20 @@ const (
 	TT_FALSE_VALUE
 	TT_NUMBER_VALUE
 	TT_INTEGER_VALUE
+	TT_RAW_VALUE
+	// TT_DOCUMENT_END follows every top-level value read in multi document mode.
+	TT_DOCUMENT_END
 )
 
-var tokenTypeNames = []string{"TT_OBJECT_START", "TT_OBJECT_END", "TT_ARRAY_START", "TT_ARRAY_END", "TT_KEY", "TT_COLON", "TT_COMMA", "TT_STRING_VALUE", "TT_NULL_VALUE", "TT_TRUE_VALUE", "TT_FALSE_VALUE", "TT_NUMBER_VALUE", "TT_INTEGER_VALUE"}
+var tokenTypeNames = []string{"TT_OBJECT_START", "TT_OBJECT_END", "TT_ARRAY_START", "TT_ARRAY_END", "TT_KEY", "TT_COLON", "TT_COMMA", "TT_STRING_VALUE", "TT_NULL_VALUE", "TT_TRUE_VALUE", "TT_FALSE_VALUE", "TT_NUMBER_VALUE", "TT_INTEGER_VALUE", "TT_RAW_VALUE", "TT_DOCUMENT_END"}
 
 type Token struct {
 	Type  TokenType
 	Value string
+	// Start and End are the byte offsets of the token in the input, only set by
+	// TokenReader with offset tracking enabled.
+	Start int64
+	End   int64
 }
 
 func (tt TokenType) Name() string {
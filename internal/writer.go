@@ -1,9 +1,16 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type tokenWriterState int
@@ -65,24 +72,316 @@ var (
 	nULL_BYTES                = []byte("null")
 	tRUE_BYTES                = []byte("true")
 	fALSE_BYTES               = []byte("false")
-	qUOTE_BYTES               = []byte("\"")
 	lINE_BREAK_BYTES          = []byte("\n")
 	sPACE_BYTES               = []byte(" ")
+	rECORD_SEPARATOR_BYTES    = []byte{RECORD_SEPARATOR}
 )
 
+const DEFAULT_BUFFER_SIZE = 4096
+
+const DEFAULT_ABORT_MARKER_KEY = "_error"
+
 type TokenWriter struct {
-	wr          io.Writer
-	indent      string
-	indentLevel int
-	stateStack  tokenWriterStateStack
+	// dest is the destination, wr is either dest or buffered
+	dest     io.Writer
+	wr       io.Writer
+	buffered *bufio.Writer
+	// text is the rendered current token, pending is what is written for it including whitespace and held tokens
+	text    []byte
+	pending []byte
+	column  int
+	held    *heldContainer
+	err     error
+	// outputBroken is set after the destination failed, the output cannot be completed any more
+	outputBroken bool
+	flushDepth   int
+	format       FormatOptions
+	stateStack   tokenWriterStateStack
+	escape       escapeOptions
+	number       numberOptions
+	// validateRawValues enables parsing raw values before writing them
+	validateRawValues bool
+	// ownsDestination enables closing the destination on Close
+	ownsDestination bool
+	abortMarkerKey  string
+	// keys holds the keys written per open object if duplicate keys are not allowed
+	duplicateKeyPolicy DuplicateKeyPolicy
+	keys               keySetStack
+	// path is the structural position of the last token written, reported by errors
+	path pathStack
+	// dropped checks the tokens of a member with a duplicate key while they are dropped
+	dropped *TokenWriter
+	limits  Limits
+	// sorter buffers object members if keys are sorted, canonical enables RFC 8785 output
+	sorter    *objectSorter
+	canonical bool
+	// filter transforms the tokens before they are checked and written
+	filter *filterStage
+	// multiDocument enables writing any number of top-level values, each preceded by documentPrefix
+	// and followed by documentSeparator
+	multiDocument     bool
+	documentPrefix    []byte
+	documentSeparator []byte
+	documents         int64
+	// maxSortBufferSize caps the memory buffered for sorting an object, 0 for no limit
+	maxSortBufferSize int
+	// completing is set by Abort and CloseAndComplete, limits, duplicate keys and the sort
+	// buffer cap are not checked and the filter is bypassed then
+	completing bool
+	// written counts the bytes written for MaxTotalBytes
+	written int64
+	// references holds the pointers, maps and slices being written by WriteValue for cycle detection
+	references map[reference]struct{}
 }
 
 func NewTokenWriter(wr io.Writer) *TokenWriter {
-	return &TokenWriter{wr: wr, indent: "", indentLevel: 0, stateStack: tokenWriterStateStack{TWS_INITIAL}}
+	return &TokenWriter{dest: wr, wr: wr, buffered: nil, flushDepth: -1, stateStack: tokenWriterStateStack{TWS_INITIAL},
+		number:            numberOptions{nonFinitePolicy: NFP_ERROR, minFixed: DEFAULT_MIN_FIXED_NUMBER, maxFixed: DEFAULT_MAX_FIXED_NUMBER},
+		validateRawValues: true, ownsDestination: true, abortMarkerKey: DEFAULT_ABORT_MARKER_KEY, documentSeparator: lINE_BREAK_BYTES}
+}
+
+// SetOwnsDestination controls if Close closes the destination when it is an io.Closer, enabled by default.
+func (t *TokenWriter) SetOwnsDestination(ownsDestination bool) {
+	t.ownsDestination = ownsDestination
 }
 
+// SetIndent enables pretty printing with indent repeated once per nesting level and a space after colons,
+// an empty indent and prefix switch back to compact output. Ignored in canonical mode.
 func (t *TokenWriter) SetIndent(indent string) {
-	t.indent = indent
+	if t.canonical {
+		return
+	}
+	t.format.Indent = indent
+	t.format.SpaceAfterColon = t.format.pretty()
+}
+
+// SetPrefix enables pretty printing with every line but the first one starting with prefix, like json.Indent.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetPrefix(prefix string) {
+	if t.canonical {
+		return
+	}
+	t.format.Prefix = prefix
+	t.format.SpaceAfterColon = t.format.pretty()
+}
+
+// SetSpaceAfterColon controls if a space follows the colon after keys, set by SetIndent and SetPrefix.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetSpaceAfterColon(spaceAfterColon bool) {
+	if t.canonical {
+		return
+	}
+	t.format.SpaceAfterColon = spaceAfterColon
+}
+
+// SetSpaceAfterComma controls if a space follows commas in compact output. Ignored in canonical mode.
+func (t *TokenWriter) SetSpaceAfterComma(spaceAfterComma bool) {
+	if t.canonical {
+		return
+	}
+	t.format.SpaceAfterComma = spaceAfterComma
+}
+
+// SetDuplicateKeyPolicy controls if a key written twice in the same object is allowed,
+// fails with a DuplicateKeyError or is dropped together with its value.
+func (t *TokenWriter) SetDuplicateKeyPolicy(policy DuplicateKeyPolicy) {
+	t.duplicateKeyPolicy = policy
+}
+
+// SetMaxTrackedKeys bounds the memory used for duplicate key detection to maxKeys keys
+// per object, keys beyond are not checked. 0 tracks all keys.
+func (t *TokenWriter) SetMaxTrackedKeys(maxKeys int) {
+	t.keys.maxKeys = maxKeys
+}
+
+// SetLimits sets limits checked before a token is written.
+func (t *TokenWriter) SetLimits(limits Limits) {
+	t.limits = limits
+}
+
+// SetCanonical enables canonical output according to RFC 8785 (JCS), suitable for hashing and
+// signatures: keys are sorted by UTF-16 code units, numbers are formatted like ECMAScript does,
+// strings use minimal escaping and no whitespace is written. It resets format, escaping and number
+// options, which cannot be changed in canonical mode, and must be called before the first token is written.
+func (t *TokenWriter) SetCanonical(canonical bool) {
+	t.canonical = canonical
+	if !canonical {
+		t.stopSorting()
+		return
+	}
+
+	t.format = COMPACT_FORMAT
+	t.escape = escapeOptions{invalidUTF8Policy: IUP_ERROR}
+	t.number = numberOptions{nonFinitePolicy: NFP_ERROR, minFixed: DEFAULT_MIN_FIXED_NUMBER, maxFixed: DEFAULT_MAX_FIXED_NUMBER}
+	t.sorter = newObjectSorter(t, lessUTF16)
+}
+
+// SetSortKeys enables writing the members of objects sorted by key, which buffers every
+// object until it ends. Keys are compared byte-wise unless a comparator is set. An object
+// buffered when sorting is disabled is still written sorted. Ignored in canonical mode.
+func (t *TokenWriter) SetSortKeys(sortKeys bool) {
+	if t.canonical {
+		return
+	}
+	if !sortKeys {
+		t.stopSorting()
+		return
+	}
+
+	t.SetKeyComparator(strings.Compare)
+}
+
+// SetKeyComparator enables writing the members of objects sorted by key in the order defined
+// by compare, which returns a negative number, zero or a positive number like strings.Compare.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetKeyComparator(compare func(a string, b string) int) {
+	if t.canonical {
+		return
+	}
+
+	less := func(a string, b string) bool {
+		return compare(a, b) < 0
+	}
+	if t.sorter != nil {
+		// keep the objects buffered so far
+		t.sorter.less = less
+		t.sorter.stopping = false
+		return
+	}
+	t.sorter = newObjectSorter(t, less)
+}
+
+// stopSorting removes the sorter, after the object being buffered has been written.
+func (t *TokenWriter) stopSorting() {
+	if t.sorter != nil && len(t.sorter.frames) > 0 {
+		t.sorter.stopping = true
+		return
+	}
+	t.sorter = nil
+}
+
+// SetMaxSortBufferSize caps the memory used for buffering an object for sorting to about
+// maxSize bytes, writing a larger object fails with a SortBufferError. 0 disables the cap.
+// Abort and CloseAndComplete exceed the cap to complete the output.
+func (t *TokenWriter) SetMaxSortBufferSize(maxSize int) {
+	t.maxSortBufferSize = maxSize
+}
+
+// SetMultiDocument enables writing a stream of top-level values like newline delimited json,
+// every completed value is followed by the document separator.
+func (t *TokenWriter) SetMultiDocument(multiDocument bool) {
+	t.multiDocument = multiDocument
+	if multiDocument && t.stateStack.Peek() == TWS_END {
+		t.stateStack.Replace(TWS_INITIAL)
+	}
+}
+
+// SetDocumentSeparator sets the separator written after every top-level value in multi document mode, "\n" by default.
+func (t *TokenWriter) SetDocumentSeparator(separator string) {
+	t.documentSeparator = []byte(separator)
+}
+
+// SetJSONTextSequence enables writing a json text sequence according to RFC 7464 for streaming,
+// every top-level value is preceded by the record separator 0x1E and followed by a line feed.
+func (t *TokenWriter) SetJSONTextSequence(jsonTextSequence bool) {
+	t.SetMultiDocument(jsonTextSequence)
+	t.documentPrefix = nil
+	if jsonTextSequence {
+		t.documentPrefix = rECORD_SEPARATOR_BYTES
+		t.documentSeparator = lINE_BREAK_BYTES
+	}
+}
+
+// DocumentCount returns the number of top-level values written completely.
+func (t *TokenWriter) DocumentCount() int64 {
+	return t.documents
+}
+
+// SetFormatOptions replaces all whitespace related settings. Ignored in canonical mode.
+func (t *TokenWriter) SetFormatOptions(options FormatOptions) {
+	if t.canonical {
+		return
+	}
+	t.format = options
+}
+
+// SetTrailingNewline enables writing a line break after the top-level value. Ignored in canonical mode.
+func (t *TokenWriter) SetTrailingNewline(trailingNewline bool) {
+	if t.canonical {
+		return
+	}
+	t.format.TrailingNewline = trailingNewline
+}
+
+// SetEscapeHTML enables escaping of <, > and & in keys and string values. Ignored in canonical mode.
+func (t *TokenWriter) SetEscapeHTML(escapeHTML bool) {
+	if t.canonical {
+		return
+	}
+	t.escape.escapeHTML = escapeHTML
+}
+
+// SetEscapeLineTerminators enables escaping of U+2028 and U+2029, which are not allowed unescaped in javascript.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetEscapeLineTerminators(escapeLineTerminators bool) {
+	if t.canonical {
+		return
+	}
+	t.escape.escapeLineTerminators = escapeLineTerminators
+}
+
+// SetASCIIOnly enables escaping of all non ascii characters as \uXXXX, using surrogate pairs where required.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetASCIIOnly(asciiOnly bool) {
+	if t.canonical {
+		return
+	}
+	t.escape.asciiOnly = asciiOnly
+}
+
+// SetNonFiniteNumberPolicy controls if NaN and ±Inf are rejected or written as null or as string.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetNonFiniteNumberPolicy(policy NonFiniteNumberPolicy) {
+	if t.canonical {
+		return
+	}
+	t.number.nonFinitePolicy = policy
+}
+
+// SetNumberNotationThresholds sets the range minFixed <= |value| < maxFixed in which
+// numbers are written in fixed notation, all others are written in exponent notation.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetNumberNotationThresholds(minFixed float64, maxFixed float64) {
+	if t.canonical {
+		return
+	}
+	t.number.minFixed = minFixed
+	t.number.maxFixed = maxFixed
+}
+
+// SetLargeIntegersAsStrings enables writing integers beyond ±(2^53-1), which javascript cannot
+// represent exactly, and big floats not exactly representable as float64 as strings.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetLargeIntegersAsStrings(largeIntegersAsStrings bool) {
+	if t.canonical {
+		return
+	}
+	t.number.largeIntegersAsStrings = largeIntegersAsStrings
+}
+
+// SetValidateRawValues controls if raw values are parsed and checked to be a single complete
+// json value before being written. Raw values are always parsed when pretty printing.
+func (t *TokenWriter) SetValidateRawValues(validateRawValues bool) {
+	t.validateRawValues = validateRawValues
+}
+
+// SetInvalidUTF8Policy controls if invalid UTF-8 in keys and string values is replaced by U+FFFD or rejected.
+// Ignored in canonical mode.
+func (t *TokenWriter) SetInvalidUTF8Policy(policy InvalidUTF8Policy) {
+	if t.canonical {
+		return
+	}
+	t.escape.invalidUTF8Policy = policy
 }
 
 func (t *TokenWriter) WriteTokens(tokens ...Token) error {
@@ -107,165 +406,229 @@ func (t *TokenWriter) checkTokenAllowed(currTokenType TokenType, allowedStates .
 	return fmt.Errorf("%s not allowed in %s", currTokenType.Name(), currState.Name())
 }
 
+// WriteToken writes a single token. The first error is recorded and returned by all
+// subsequent writes, so many tokens can be written and the error checked once.
 func (t *TokenWriter) WriteToken(token Token) error {
+	if t.err != nil {
+		return t.err
+	}
 
-	err := t.addMissingTokens(token)
+	var err error
+	switch {
+	case t.filter == nil:
+		err = t.writeFilteredToken(token)
+	case t.completing:
+		// the tokens completing the output must not be dropped or changed
+		err = t.filter.bypass(token)
+	default:
+		err = t.filter.writeToken(token)
+	}
 	if err != nil {
-		return err
+		return t.fail(err)
 	}
 
-	switch token.Type {
-	case TT_OBJECT_START:
-		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		_, err = t.wr.Write(cURLY_BRACKET_LEFT_BYTES)
-		if err != nil {
-			return err
-		}
-		if t.indent != "" {
-			t.indentLevel++
-		}
-		t.stateStack.Push(TWS_IN_OBJECT)
+// writeFilteredToken writes a token that has passed the filter.
+func (t *TokenWriter) writeFilteredToken(token Token) error {
+	if t.sorter != nil {
+		return t.sorter.writeToken(t, token)
+	}
+
+	return t.writeToken(token)
+}
+
+// SetTokenFilter sets a filter, like a FilterChain, every token written passes before it is
+// checked and written. Tokens written by Abort and CloseAndComplete to complete the output
+// bypass it. nil removes the filter.
+func (t *TokenWriter) SetTokenFilter(filter TokenFilter) {
+	t.filter = nil
+	if filter != nil {
+		t.filter = newFilterStage(filter, t.writeFilteredToken)
+	}
+}
+
+// TokenFilter returns the filter set by SetTokenFilter, nil if there is none.
+func (t *TokenWriter) TokenFilter() TokenFilter {
+	if t.filter == nil {
 		return nil
-	case TT_OBJECT_END:
-		err := t.checkTokenAllowed(token.Type, TWS_IN_OBJECT, TWS_IN_OBJECT_PAIR_SEEN)
-		if err != nil {
-			return err
+	}
+
+	return t.filter.filter
+}
+
+// endStateReached reports if all values written so far are complete.
+func (t *TokenWriter) endStateReached() bool {
+	state := t.currentState()
+	return state == TWS_END || (t.multiDocument && state == TWS_INITIAL)
+}
+
+// currentState returns the state as seen by the caller, which is ahead of the output
+// while object members are buffered for sorting or a member with a duplicate key is dropped.
+func (t *TokenWriter) currentState() tokenWriterState {
+	if t.sorter != nil {
+		return t.sorter.shadow.currentState()
+	}
+	if t.dropped != nil {
+		return t.dropped.stateStack.Peek()
+	}
+
+	return t.stateStack.Peek()
+}
+
+// fail records err as sticky error unless an error has been recorded before, and returns the recorded one.
+func (t *TokenWriter) fail(err error) error {
+	if t.err == nil {
+		t.err = err
+	}
+
+	return t.err
+}
+
+// Err returns the first error that occurred while writing.
+func (t *TokenWriter) Err() error {
+	return t.err
+}
+
+func (t *TokenWriter) writeToken(token Token) error {
+	// document boundaries read in multi document mode produce no output
+	if token.Type == TT_DOCUMENT_END {
+		if !t.endStateReached() {
+			return fmt.Errorf("%s not allowed in %s", token.Type.Name(), t.stateStack.Peek().Name())
 		}
+		return nil
+	}
 
-		if t.indent != "" {
-			_, err := t.wr.Write(lINE_BREAK_BYTES)
-			if err != nil {
-				return err
-			}
-			t.indentLevel--
+	// fail before a separator is added for a value that cannot be written
+	if (token.Type == TT_STRING_VALUE || token.Type == TT_KEY) && t.escape.invalidUTF8Policy == IUP_ERROR && !utf8.ValidString(token.Value) {
+		_, err := appendQuoted(nil, token.Value, &t.escape)
+		return err
+	}
 
-			err = t.writeIndent()
-			if err != nil {
-				return err
-			}
+	if t.dropped != nil {
+		dropped, err := t.dropToken(token)
+		if dropped || err != nil {
+			return err
 		}
+	}
 
-		_, err = t.wr.Write(cURLY_BRACKET_RIGHT_BYTES)
+	// limits and duplicate keys are not checked while the output is completed
+	if !t.completing {
+		err := t.checkLimits(token)
 		if err != nil {
 			return err
 		}
-		_ = t.stateStack.Pop()
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
+	}
+
+	if token.Type == TT_KEY && t.duplicateKeyPolicy != DKP_ALLOW && len(t.keys.sets) > 0 && !t.completing {
+		if currentState := t.stateStack.Peek(); (currentState == TWS_IN_OBJECT || currentState == TWS_IN_OBJECT_PAIR_SEEN || currentState == TWS_IN_OBJECT_COMMA_SEEN) && !t.keys.Add(token.Value) {
+			if t.duplicateKeyPolicy == DKP_ERROR {
+				return &DuplicateKeyError{Key: token.Value, Path: t.tokenPath(token)}
+			}
+			t.dropped = NewTokenWriter(ioutil.Discard)
+			t.dropped.stateStack = tokenWriterStateStack{TWS_INITIAL, TWS_IN_OBJECT_KEY_SEEN}
+			return nil
 		}
-		return nil
-	case TT_ARRAY_START:
+	}
+
+	err := t.addMissingTokens(token)
+	if err != nil {
+		return err
+	}
+
+	if token.Type == TT_KEY && len(t.keys.counts) > 0 {
+		t.keys.Count()
+	}
+
+	// every token is rendered completely before it is written with a single call,
+	// the state is only advanced after a successful write
+	t.text = t.text[:0]
+	switch token.Type {
+	case TT_OBJECT_START, TT_ARRAY_START:
 		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
 		if err != nil {
 			return err
 		}
 
-		_, err = t.wr.Write(rECT_BRACKET_LEFT_BYTES)
+		if token.Type == TT_OBJECT_START {
+			t.text = append(t.text, cURLY_BRACKET_LEFT_BYTES...)
+		} else {
+			t.text = append(t.text, rECT_BRACKET_LEFT_BYTES...)
+		}
+		err = t.emit(token)
 		if err != nil {
 			return err
 		}
-		if t.indent != "" {
-			t.indentLevel++
+
+		t.path.NextValue()
+		if token.Type == TT_OBJECT_START {
+			t.stateStack.Push(TWS_IN_OBJECT)
+			t.path.PushObject()
+			t.keys.Push()
+		} else {
+			t.stateStack.Push(TWS_IN_ARRAY)
+			t.path.PushArray()
 		}
-		t.stateStack.Push(TWS_IN_ARRAY)
 		return nil
-	case TT_ARRAY_END:
-		err := t.checkTokenAllowed(token.Type, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN, TWS_IN_ARRAY_ITEM_SEEN)
+	case TT_OBJECT_END, TT_ARRAY_END:
+		var err error
+		if token.Type == TT_OBJECT_END {
+			err = t.checkTokenAllowed(token.Type, TWS_IN_OBJECT, TWS_IN_OBJECT_PAIR_SEEN)
+		} else {
+			err = t.checkTokenAllowed(token.Type, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN, TWS_IN_ARRAY_ITEM_SEEN)
+		}
 		if err != nil {
 			return err
 		}
 
-		if t.indent != "" {
-			_, err := t.wr.Write(lINE_BREAK_BYTES)
-			if err != nil {
-				return err
-			}
-			t.indentLevel--
-
-			err = t.writeIndent()
-			if err != nil {
-				return err
-			}
+		if token.Type == TT_OBJECT_END {
+			t.text = append(t.text, cURLY_BRACKET_RIGHT_BYTES...)
+		} else {
+			t.text = append(t.text, rECT_BRACKET_RIGHT_BYTES...)
 		}
-
-		_, err = t.wr.Write(rECT_BRACKET_RIGHT_BYTES)
+		err = t.emit(token)
 		if err != nil {
 			return err
 		}
 
-		_ = t.stateStack.Pop()
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
+		if token.Type == TT_OBJECT_END {
+			t.keys.Pop()
 		}
-
-		return nil
+		_ = t.stateStack.Pop()
+		t.path.Pop()
+		return t.valueWritten()
 	case TT_KEY:
 		err := t.checkTokenAllowed(token.Type, TWS_IN_OBJECT, TWS_IN_OBJECT_COMMA_SEEN)
 		if err != nil {
 			return err
 		}
 
-		if t.indent != "" {
-			_, err := t.wr.Write(lINE_BREAK_BYTES)
-			if err != nil {
-				return err
-			}
-
-			err = t.writeIndent()
-			if err != nil {
-				return err
-			}
-		}
-
-		_, err = t.wr.Write(qUOTE_BYTES)
+		t.text, err = appendQuoted(t.text, token.Value, &t.escape)
 		if err != nil {
 			return err
 		}
 
-		_, err = t.wr.Write([]byte(token.Value))
+		err = t.emit(token)
 		if err != nil {
 			return err
 		}
 
-		_, err = t.wr.Write(qUOTE_BYTES)
-		if err != nil {
-			return err
-		}
 		t.stateStack.Replace(TWS_IN_OBJECT_KEY_SEEN)
+		t.path.SetKey(token.Value)
 		return nil
 	case TT_COLON:
 		err := t.checkTokenAllowed(token.Type, TWS_IN_OBJECT_KEY_SEEN)
 		if err != nil {
 			return err
 		}
-		_, err = t.wr.Write(cOLON_BYTES)
+
+		t.text = append(t.text, cOLON_BYTES...)
+		err = t.emit(token)
 		if err != nil {
 			return err
 		}
 
-		if t.indent != "" {
-			_, err = t.wr.Write(sPACE_BYTES)
-			if err != nil {
-				return err
-			}
-		}
-
 		t.stateStack.Replace(TWS_IN_OBJECT_COLON_SEEN)
 		return nil
 	case TT_COMMA:
@@ -274,172 +637,366 @@ func (t *TokenWriter) WriteToken(token Token) error {
 			return err
 		}
 
-		_, err = t.wr.Write(cOMMA_BYTES)
+		t.text = append(t.text, cOMMA_BYTES...)
+		err = t.emit(token)
 		if err != nil {
 			return err
 		}
 
 		if t.stateStack.Peek() == TWS_IN_OBJECT_PAIR_SEEN {
 			t.stateStack.Replace(TWS_IN_OBJECT_COMMA_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_ITEM_SEEN {
+		} else {
 			t.stateStack.Replace(TWS_IN_ARRAY_COMMA_SEEN)
 		}
-
 		return nil
-	case TT_STRING_VALUE:
+	case TT_STRING_VALUE, TT_NULL_VALUE, TT_TRUE_VALUE, TT_FALSE_VALUE, TT_NUMBER_VALUE, TT_INTEGER_VALUE, TT_RAW_VALUE:
 		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
 		if err != nil {
 			return err
 		}
 
-		if t.stateStack.Peek() == TWS_IN_ARRAY || t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			if t.indent != "" {
-				_, err := t.wr.Write(lINE_BREAK_BYTES)
-				if err != nil {
-					return err
-				}
-
-				err = t.writeIndent()
+		switch token.Type {
+		case TT_STRING_VALUE:
+			t.text, err = appendQuoted(t.text, token.Value, &t.escape)
+			if err != nil {
+				return err
+			}
+		case TT_NULL_VALUE:
+			t.text = append(t.text, nULL_BYTES...)
+		case TT_TRUE_VALUE:
+			t.text = append(t.text, tRUE_BYTES...)
+		case TT_FALSE_VALUE:
+			t.text = append(t.text, fALSE_BYTES...)
+		case TT_NUMBER_VALUE, TT_INTEGER_VALUE:
+			if t.canonical {
+				t.text, err = appendCanonicalNumber(t.text, token.Value)
 				if err != nil {
 					return err
 				}
+			} else {
+				t.text = append(t.text, token.Value...)
 			}
+		default:
+			t.text = append(t.text, token.Value...)
 		}
-
-		_, err = t.wr.Write([]byte(fmt.Sprintf("\"%s\"", token.Value)))
+		err = t.emit(token)
 		if err != nil {
 			return err
 		}
 
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		}
+		t.path.NextValue()
+		return t.valueWritten()
+	default:
+		return fmt.Errorf("invalid token type: %d", token.Type)
+	}
+}
 
-		return nil
-	case TT_NULL_VALUE:
-		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
-		if err != nil {
-			return err
+// tokenPath returns the path of token, which has not been written yet.
+func (t *TokenWriter) tokenPath(token Token) string {
+	path := append(pathStack{}, t.path...)
+	switch token.Type {
+	case TT_KEY:
+		if len(path) > 0 {
+			path.SetKey(token.Value)
 		}
+	case TT_OBJECT_END, TT_ARRAY_END, TT_COLON, TT_COMMA:
+	default:
+		path.NextValue()
+	}
 
-		_, err = t.wr.Write(nULL_BYTES)
-		if err != nil {
-			return err
+	return path.String()
+}
+
+// checkLimits checks the token against depth, string length and keys per object limits.
+func (t *TokenWriter) checkLimits(token Token) error {
+	switch token.Type {
+	case TT_OBJECT_START, TT_ARRAY_START:
+		if t.limits.MaxDepth > 0 && len(t.stateStack) > t.limits.MaxDepth {
+			return &MaxDepthError{Limit: t.limits.MaxDepth, Path: t.tokenPath(token)}
+		}
+	case TT_KEY, TT_STRING_VALUE:
+		if t.limits.MaxStringLength > 0 && len(token.Value) > t.limits.MaxStringLength {
+			return &MaxStringLengthError{Limit: t.limits.MaxStringLength, Path: t.tokenPath(token)}
+		}
+		if token.Type == TT_KEY && t.limits.MaxKeysPerObject > 0 && len(t.keys.counts) > 0 && t.keys.counts[len(t.keys.counts)-1] >= t.limits.MaxKeysPerObject {
+			return &MaxKeysPerObjectError{Limit: t.limits.MaxKeysPerObject, Path: t.tokenPath(token)}
 		}
+	}
+
+	return nil
+}
+
+// dropToken checks and drops a token of a member with a duplicate key, dropping ends after its value.
+// The end of the enclosing object ends a member without value, it is not dropped.
+func (t *TokenWriter) dropToken(token Token) (bool, error) {
+	dropped := t.dropped
+	if len(dropped.stateStack) == 2 && (token.Type == TT_OBJECT_END || token.Type == TT_ARRAY_END) {
+		t.dropped = nil
+		return false, nil
+	}
 
-		if t.stateStack.Peek() == TWS_INITIAL {
+	err := dropped.writeToken(token)
+	if err != nil {
+		return true, err
+	}
+
+	if len(dropped.stateStack) == 2 && dropped.stateStack.Peek() == TWS_IN_OBJECT_PAIR_SEEN {
+		t.dropped = nil
+	}
+	return true, nil
+}
+
+// valueWritten advances the state after a complete value and flushes if the
+// value ends at or above the flush depth.
+func (t *TokenWriter) valueWritten() error {
+	switch t.stateStack.Peek() {
+	case TWS_INITIAL:
+		t.documents++
+		if !t.multiDocument {
 			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
 		}
+	case TWS_IN_OBJECT_COLON_SEEN:
+		t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
+	case TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN:
+		t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
+	}
 
+	if t.flushDepth >= 0 && len(t.stateStack)-1 <= t.flushDepth {
+		return t.Flush()
+	}
+
+	return nil
+}
+
+func (t *TokenWriter) writePending() error {
+	if len(t.pending) == 0 {
 		return nil
-	case TT_TRUE_VALUE:
-		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
-		if err != nil {
-			return err
-		}
+	}
 
-		_, err = t.wr.Write(tRUE_BYTES)
-		if err != nil {
-			return err
-		}
+	if t.limits.MaxTotalBytes > 0 && t.written+int64(len(t.pending)) > t.limits.MaxTotalBytes {
+		return &MaxTotalBytesError{Limit: t.limits.MaxTotalBytes, Path: t.path.String()}
+	}
 
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		}
+	_, err := t.wr.Write(t.pending)
+	if err != nil {
+		t.outputBroken = true
+		return err
+	}
 
+	t.written += int64(len(t.pending))
+	t.column = t.pendingColumn()
+	return nil
+}
+
+// SetBufferSize enables buffering of the output with the given buffer size, 0 disables buffering.
+// Buffered output is written when the buffer is full, on Flush and on Close.
+func (t *TokenWriter) SetBufferSize(size int) error {
+	if t.err != nil {
+		return t.err
+	}
+
+	err := t.flushBuffer()
+	if err != nil {
+		return t.fail(err)
+	}
+
+	if size <= 0 {
+		t.buffered = nil
+		t.wr = t.dest
 		return nil
-	case TT_FALSE_VALUE:
-		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
+	}
+
+	t.buffered = bufio.NewWriterSize(t.dest, size)
+	t.wr = t.buffered
+	return nil
+}
+
+// SetFlushDepth enables flushing whenever a value at nesting depth <= depth has been completed:
+// 0 flushes after the top-level value, 1 after every item of the top-level container.
+// A negative depth disables flushing on depth.
+func (t *TokenWriter) SetFlushDepth(depth int) {
+	t.flushDepth = depth
+}
+
+func (t *TokenWriter) flushBuffer() error {
+	if t.buffered == nil {
+		return nil
+	}
+
+	err := t.buffered.Flush()
+	if err != nil {
+		t.outputBroken = true
+	}
+	return err
+}
+
+// Flush writes buffered output to the destination and flushes the destination if it supports it,
+// like bufio.Writer or http.Flusher. Tokens held back for the layout are written too, so an array
+// being written is not kept on a single line and object members are aligned up to here only.
+func (t *TokenWriter) Flush() error {
+	if t.err != nil {
+		return t.err
+	}
+
+	err := t.writeHeld()
+	if err != nil {
+		return t.fail(err)
+	}
+
+	err = t.flushBuffer()
+	if err != nil {
+		return t.fail(err)
+	}
+
+	switch flusher := t.dest.(type) {
+	case interface{ Flush() error }:
+		err := flusher.Flush()
 		if err != nil {
-			return err
+			t.outputBroken = true
+			return t.fail(err)
 		}
+	case interface{ Flush() }:
+		flusher.Flush()
+	}
+
+	return nil
+}
+
+// Finish flushes the output and checks that a complete value has been written,
+// the destination is left open.
+func (t *TokenWriter) Finish() error {
+	err := t.Flush()
+	if err != nil {
+		return err
+	}
+
+	if !t.endStateReached() {
+		return fmt.Errorf("not in end state")
+	}
+
+	return nil
+}
 
-		_, err = t.wr.Write(fALSE_BYTES)
+// Close finishes the output and closes the destination if it is an io.Closer owned by the writer.
+// The destination is closed even if finishing fails.
+func (t *TokenWriter) Close() error {
+	err := t.Finish()
+	closer, isCloser := t.dest.(io.Closer)
+	if isCloser && t.ownsDestination {
+		closeErr := closer.Close()
 		if err != nil {
 			return err
 		}
+		return closeErr
+	}
 
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
+	return err
+}
+
+// CloseAndComplete writes null for a missing value and the ends of all open
+// containers before closing, so partially written output is still valid json.
+// A member left open by a comma gets the abort marker key, made unique if duplicate
+// keys are checked, and null. Limits and duplicate keys are not checked meanwhile.
+func (t *TokenWriter) CloseAndComplete() error {
+	t.completing = true
+	err := t.complete()
+	t.completing = false
+	if err != nil {
+		closer, isCloser := t.dest.(io.Closer)
+		if isCloser && t.ownsDestination {
+			_ = closer.Close()
 		}
+		return err
+	}
 
-		return nil
-	case TT_NUMBER_VALUE:
-		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
-		if err != nil {
-			return err
+	return t.Close()
+}
+
+// SetAbortMarkerKey sets the key of the error marker property written by Abort, "_error" by default.
+func (t *TokenWriter) SetAbortMarkerKey(key string) {
+	t.abortMarkerKey = key
+}
+
+// Abort ends partially written output with valid json: if errValue is not nil an error marker
+// property is added to the innermost object, or an object holding it to the innermost array,
+// then all open containers are ended and the output is flushed. The destination is left open.
+// The marker is left out if it cannot be written, its key is made unique if duplicate keys are
+// checked. Limits and duplicate keys are not checked meanwhile. An error is only returned if the
+// output could not be completed; a previously recorded error is kept unless the destination itself failed.
+func (t *TokenWriter) Abort(errValue interface{}) error {
+	if t.outputBroken {
+		return t.err
+	}
+
+	recordedErr := t.err
+	t.err = nil
+	t.completing = true
+	defer func() {
+		t.completing = false
+		if recordedErr != nil {
+			t.err = recordedErr
 		}
+	}()
 
-		_, err = t.wr.Write([]byte(token.Value))
-		if err != nil {
+	if errValue != nil {
+		err := t.writeAbortMarker(errValue)
+		if err != nil && t.outputBroken {
 			return err
 		}
+		// the marker is best effort, the output is completed without it
+		t.err = nil
+	}
 
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
+	err := t.complete()
+	if err != nil {
+		return err
+	}
+	if t.filter != nil {
+		// the filter may have dropped tokens of the value ended, its path is not reliable
+		t.filter.reset()
+	}
+
+	return t.Flush()
+}
+
+func (t *TokenWriter) writeAbortMarker(errValue interface{}) error {
+	// a member being dropped would swallow the marker
+	for t.droppingMember() {
+		err := t.completeToken()
+		if err != nil {
+			return err
 		}
+	}
 
+	switch t.currentState() {
+	case TWS_END:
 		return nil
-	case TT_INTEGER_VALUE:
-		err := t.checkTokenAllowed(token.Type, TWS_INITIAL, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_COMMA_SEEN)
+	case TWS_IN_OBJECT_KEY_SEEN, TWS_IN_OBJECT_COLON_SEEN:
+		err := t.WriteNullValue()
 		if err != nil {
 			return err
 		}
-
-		_, err = t.wr.Write([]byte(token.Value))
+	case TWS_IN_ARRAY_COMMA_SEEN, TWS_IN_ARRAY, TWS_IN_ARRAY_ITEM_SEEN, TWS_INITIAL:
+		err := t.WriteObjectStart()
 		if err != nil {
 			return err
 		}
+	}
 
-		if t.stateStack.Peek() == TWS_INITIAL {
-			t.stateStack.Replace(TWS_END)
-		} else if t.stateStack.Peek() == TWS_IN_OBJECT_COLON_SEEN {
-			t.stateStack.Replace(TWS_IN_OBJECT_PAIR_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		} else if t.stateStack.Peek() == TWS_IN_ARRAY_COMMA_SEEN {
-			t.stateStack.Replace(TWS_IN_ARRAY_ITEM_SEEN)
-		}
+	err := t.WriteKey(t.unusedKey(t.abortMarkerKey))
+	if err != nil {
+		return err
+	}
 
-		return nil
-	default:
-		return fmt.Errorf("invalid token type: %d", token.Type)
+	if value, isError := errValue.(error); isError {
+		return t.WriteStringValue(value.Error())
 	}
+	return t.WriteValue(errValue)
 }
 
-func (t *TokenWriter) writeIndent() error {
-	for i := 0; i < t.indentLevel; i++ {
-		_, err := t.wr.Write([]byte(t.indent))
+func (t *TokenWriter) complete() error {
+	for !t.endStateReached() {
+		err := t.completeToken()
 		if err != nil {
 			return err
 		}
@@ -448,28 +1005,42 @@ func (t *TokenWriter) writeIndent() error {
 	return nil
 }
 
-func (t *TokenWriter) writeBytes(bss ...[]byte) error {
-	for _, bs := range bss {
-		_, err := t.wr.Write(bs)
-		if err != nil {
-			return err
-		}
+// completeToken writes the next token completing the output.
+func (t *TokenWriter) completeToken() error {
+	switch t.currentState() {
+	case TWS_INITIAL, TWS_IN_OBJECT_KEY_SEEN, TWS_IN_OBJECT_COLON_SEEN, TWS_IN_ARRAY_COMMA_SEEN:
+		return t.WriteNullValue()
+	case TWS_IN_ARRAY, TWS_IN_ARRAY_ITEM_SEEN:
+		return t.WriteArrayEnd()
+	case TWS_IN_OBJECT_COMMA_SEEN:
+		// the comma has been written already, it needs a member to follow
+		return t.WriteKey(t.unusedKey(t.abortMarkerKey))
+	default:
+		return t.WriteObjectEnd()
 	}
-
-	return nil
 }
 
-func (t *TokenWriter) Close() error {
-	closer, isCloser := t.wr.(io.Closer)
-	if isCloser {
-		return closer.Close()
+// droppingMember reports if the caller is writing a member with a duplicate key that is dropped.
+func (t *TokenWriter) droppingMember() bool {
+	if t.sorter != nil {
+		return t.sorter.shadow.droppingMember()
 	}
 
-	if t.stateStack.Peek() != TWS_END {
-		return fmt.Errorf("not in end state")
+	return t.dropped != nil
+}
+
+// unusedKey returns key, or if it has been written to the innermost object already key
+// followed by a number making it unique. Keys are only known if duplicate keys are checked.
+func (t *TokenWriter) unusedKey(key string) string {
+	if t.sorter != nil {
+		return t.sorter.shadow.unusedKey(key)
 	}
 
-	return nil
+	unused := key
+	for i := 2; t.keys.Has(unused); i++ {
+		unused = key + "_" + strconv.Itoa(i)
+	}
+	return unused
 }
 
 func (t *TokenWriter) WriteObjectStart() error {
@@ -497,15 +1068,104 @@ func (t *TokenWriter) WriteBooleanValue(value bool) error {
 	return t.WriteToken(Token{Type: TT_FALSE_VALUE, Value: ""})
 }
 func (t *TokenWriter) WriteNumberValue(value float64) error {
-	return t.WriteToken(Token{Type: TT_NUMBER_VALUE, Value: fmt.Sprintf("%e", value)})
+	return t.writeFloatValue(value, 64)
+}
+func (t *TokenWriter) writeFloatValue(value float64, bitSize int) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		switch t.number.nonFinitePolicy {
+		case NFP_NULL:
+			return t.WriteNullValue()
+		case NFP_STRING:
+			return t.WriteStringValue(nonFiniteName(value))
+		default:
+			if t.err != nil {
+				return t.err
+			}
+			return t.fail(fmt.Errorf("unsupported number value %s", nonFiniteName(value)))
+		}
+	}
+
+	return t.WriteToken(Token{Type: TT_NUMBER_VALUE, Value: string(appendFloat(nil, value, bitSize, &t.number))})
 }
 func (t *TokenWriter) WriteIntegerValue(value int) error {
-	return t.WriteToken(Token{Type: TT_INTEGER_VALUE, Value: strconv.Itoa(value)})
+	return t.WriteInt64Value(int64(value))
+}
+func (t *TokenWriter) WriteInt64Value(value int64) error {
+	if t.number.largeIntegersAsStrings && (value > MAX_SAFE_INTEGER || value < -MAX_SAFE_INTEGER) {
+		return t.WriteStringValue(strconv.FormatInt(value, 10))
+	}
+	return t.WriteToken(Token{Type: TT_INTEGER_VALUE, Value: strconv.FormatInt(value, 10)})
+}
+func (t *TokenWriter) WriteUint64Value(value uint64) error {
+	if t.number.largeIntegersAsStrings && value > MAX_SAFE_INTEGER {
+		return t.WriteStringValue(strconv.FormatUint(value, 10))
+	}
+	return t.WriteToken(Token{Type: TT_INTEGER_VALUE, Value: strconv.FormatUint(value, 10)})
+}
+func (t *TokenWriter) WriteBigIntValue(value *big.Int) error {
+	if value == nil {
+		return t.WriteNullValue()
+	}
+	if t.number.largeIntegersAsStrings && (value.Cmp(maxSafeBigInt) > 0 || value.Cmp(minSafeBigInt) < 0) {
+		return t.WriteStringValue(value.String())
+	}
+	return t.WriteToken(Token{Type: TT_INTEGER_VALUE, Value: value.String()})
+}
+func (t *TokenWriter) WriteBigFloatValue(value *big.Float) error {
+	if value == nil {
+		return t.WriteNullValue()
+	}
+	if value.IsInf() {
+		if value.Signbit() {
+			return t.WriteNumberValue(math.Inf(-1))
+		}
+		return t.WriteNumberValue(math.Inf(1))
+	}
+	// values not exactly representable as float64 lose precision in javascript
+	if _, accuracy := value.Float64(); t.number.largeIntegersAsStrings && accuracy != big.Exact {
+		return t.WriteStringValue(value.Text('g', -1))
+	}
+	return t.WriteToken(Token{Type: TT_NUMBER_VALUE, Value: value.Text('g', -1)})
 }
 func (t *TokenWriter) WriteNullValue() error {
 	return t.WriteToken(Token{Type: TT_NULL_VALUE, Value: ""})
 }
 
+// WriteRawValue writes an already encoded json value. Unless validation is disabled and not pretty
+// printing or filtering, value is parsed and written token by token, so it is re-indented and cannot break the structure.
+func (t *TokenWriter) WriteRawValue(value []byte) error {
+	if !t.validateRawValues && !t.format.pretty() && t.sorter == nil && t.filter == nil {
+		return t.WriteToken(Token{Type: TT_RAW_VALUE, Value: string(bytes.TrimSpace(value))})
+	}
+
+	if t.err != nil {
+		return t.err
+	}
+
+	tokens, err := parseRawValue(value)
+	if err != nil {
+		return t.fail(err)
+	}
+
+	return t.WriteTokens(tokens...)
+}
+
+func parseRawValue(value []byte) ([]Token, error) {
+	rd := NewTokenReader(bytes.NewReader(value))
+	tokens := []Token{}
+	for {
+		token, err := rd.ReadToken()
+		if err == io.EOF {
+			return tokens, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid raw value: %w", err)
+		}
+
+		tokens = append(tokens, token)
+	}
+}
+
 func (t *TokenWriter) addMissingTokens(token Token) error {
 
 	currentState := t.stateStack.Peek()
@@ -514,21 +1174,23 @@ func (t *TokenWriter) addMissingTokens(token Token) error {
 		token.Type == TT_STRING_VALUE ||
 		token.Type == TT_TRUE_VALUE ||
 		token.Type == TT_FALSE_VALUE ||
+		token.Type == TT_NULL_VALUE ||
+		token.Type == TT_RAW_VALUE ||
 		token.Type == TT_OBJECT_START ||
 		token.Type == TT_ARRAY_START
 
 	if followsValue && currentState == TWS_IN_ARRAY_ITEM_SEEN {
-		err := t.WriteToken(Token{Type: TT_COMMA, Value: ""})
+		err := t.writeToken(Token{Type: TT_COMMA, Value: ""})
 		if err != nil {
 			return err
 		}
 	} else if followsValue && currentState == TWS_IN_OBJECT_KEY_SEEN {
-		err := t.WriteToken(Token{Type: TT_COLON, Value: ""})
+		err := t.writeToken(Token{Type: TT_COLON, Value: ""})
 		if err != nil {
 			return err
 		}
 	} else if token.Type == TT_KEY && currentState == TWS_IN_OBJECT_PAIR_SEEN {
-		err := t.WriteToken(Token{Type: TT_COMMA, Value: ""})
+		err := t.writeToken(Token{Type: TT_COMMA, Value: ""})
 		if err != nil {
 			return err
 		}
@@ -573,6 +1235,51 @@ func (t *TokenWriter) WriteKeyAndIntegerValue(key string, value int) error {
 	return t.WriteIntegerValue(value)
 }
 
+func (t *TokenWriter) WriteKeyAndInt64Value(key string, value int64) error {
+	err := t.WriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteInt64Value(value)
+}
+
+func (t *TokenWriter) WriteKeyAndUint64Value(key string, value uint64) error {
+	err := t.WriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteUint64Value(value)
+}
+
+func (t *TokenWriter) WriteKeyAndBigIntValue(key string, value *big.Int) error {
+	err := t.WriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteBigIntValue(value)
+}
+
+func (t *TokenWriter) WriteKeyAndBigFloatValue(key string, value *big.Float) error {
+	err := t.WriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteBigFloatValue(value)
+}
+
+func (t *TokenWriter) WriteKeyAndRawValue(key string, value []byte) error {
+	err := t.WriteKey(key)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteRawValue(value)
+}
+
 func (t *TokenWriter) WriteKeyAndNullValue(key string) error {
 	err := t.WriteKey(key)
 	if err != nil {
@@ -2,7 +2,11 @@ package internal
 
 import (
 	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -61,6 +65,15 @@ func TestWritesEmptyArray(t *testing.T) {
 	testProducesJsonViaTokenStream(t, "", expectedJson, tokens...)
 }
 
+func TestAddsMissingSeparatorsBeforeNull(t *testing.T) {
+
+	tokens := []Token{{Type: TT_ARRAY_START, Value: ""}, {Type: TT_INTEGER_VALUE, Value: "1"}, {Type: TT_NULL_VALUE, Value: ""},
+		{Type: TT_OBJECT_START, Value: ""}, {Type: TT_KEY, Value: "a"}, {Type: TT_NULL_VALUE, Value: ""}, {Type: TT_OBJECT_END, Value: ""},
+		{Type: TT_NULL_VALUE, Value: ""}, {Type: TT_ARRAY_END, Value: ""}}
+	expectedJson := "[1,null,{\"a\":null},null]"
+	testProducesJsonViaTokenStream(t, "", expectedJson, tokens...)
+}
+
 func TestWritesStringArrayNoIndent(t *testing.T) {
 
 	tokens := []Token{{Type: TT_ARRAY_START, Value: ""}, {Type: TT_STRING_VALUE, Value: "value0"}, {Type: TT_COMMA, Value: ""}, {Type: TT_STRING_VALUE, Value: "value1"}, {Type: TT_ARRAY_END, Value: ""}}
@@ -104,6 +117,699 @@ func TestWritesMultiItemArrayWithObjectsTabIndent(t *testing.T) {
 	tokens := []Token{{Type: TT_ARRAY_START, Value: ""}, {Type: TT_STRING_VALUE, Value: "value0"}, {Type: TT_COMMA, Value: ""},
 		{Type: TT_OBJECT_START, Value: ""}, {Type: TT_KEY, Value: "key1"}, {Type: TT_COLON, Value: ":"}, {Type: TT_STRING_VALUE, Value: "value1"}, {Type: TT_OBJECT_END, Value: ""}, {Type: TT_COMMA, Value: ""},
 		{Type: TT_STRING_VALUE, Value: "value2"}, {Type: TT_ARRAY_END, Value: ""}}
-	expectedJson := "[\n\t\"value0\",{\n\t\t\"key1\": \"value1\"\n\t},\n\t\"value2\"\n]"
+	expectedJson := "[\n\t\"value0\",\n\t{\n\t\t\"key1\": \"value1\"\n\t},\n\t\"value2\"\n]"
 	testProducesJsonViaTokenStream(t, indent, expectedJson, tokens...)
 }
+
+func TestWritesEscapedKeyAndString(t *testing.T) {
+	tokens := []Token{{Type: TT_OBJECT_START, Value: ""}, {Type: TT_KEY, Value: "k\"e\\y"}, {Type: TT_STRING_VALUE, Value: "line1\nline2\t\x01"}, {Type: TT_OBJECT_END, Value: ""}}
+	expectedJson := "{\"k\\\"e\\\\y\":\"line1\\nline2\\t\\u0001\"}"
+	testProducesJsonViaTokenStream(t, "", expectedJson, tokens...)
+}
+
+func TestFailsOnInvalidUTF8WithErrorPolicy(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetInvalidUTF8Policy(IUP_ERROR)
+
+	err := wr.WriteStringValue("a\xffb")
+	assert.EqualError(t, err, "invalid UTF-8 at byte offset 1")
+	assert.Equal(t, "", buf.String())
+}
+
+func TestWritesNumbersShortest(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	err := wr.WriteArrayStart()
+	assert.NoError(t, err)
+	for _, value := range []float64{1.5, 0.1, 1e-7, 1e21, -3} {
+		err = wr.WriteNumberValue(value)
+		assert.NoError(t, err)
+	}
+	err = wr.WriteArrayEnd()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "[1.5,0.1,1e-7,1e+21,-3]", buf.String())
+}
+
+func TestFailsOnNonFiniteNumberByDefault(t *testing.T) {
+	wr := NewTokenWriter(new(bytes.Buffer))
+
+	err := wr.WriteNumberValue(math.NaN())
+	assert.EqualError(t, err, "unsupported number value NaN")
+}
+
+func TestWritesNonFiniteNumbersAccordingToPolicy(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	err := wr.WriteArrayStart()
+	assert.NoError(t, err)
+	wr.SetNonFiniteNumberPolicy(NFP_NULL)
+	err = wr.WriteNumberValue(math.Inf(1))
+	assert.NoError(t, err)
+	wr.SetNonFiniteNumberPolicy(NFP_STRING)
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		err = wr.WriteNumberValue(value)
+		assert.NoError(t, err)
+	}
+	err = wr.WriteArrayEnd()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "[null,\"NaN\",\"Infinity\",\"-Infinity\"]", buf.String())
+}
+
+func TestWritesIntegersOfAllWidths(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	bigInt, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	bigFloat, _ := new(big.Float).SetPrec(200).SetString("1.25")
+	err := wr.WriteArrayStart()
+	assert.NoError(t, err)
+	assert.NoError(t, wr.WriteInt64Value(math.MinInt64))
+	assert.NoError(t, wr.WriteUint64Value(math.MaxUint64))
+	assert.NoError(t, wr.WriteBigIntValue(bigInt))
+	assert.NoError(t, wr.WriteBigFloatValue(bigFloat))
+	assert.NoError(t, wr.WriteBigIntValue(nil))
+	err = wr.WriteArrayEnd()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "[-9223372036854775808,18446744073709551615,123456789012345678901234567890,1.25,null]", buf.String())
+}
+
+func TestWritesLargeIntegersAsStrings(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetLargeIntegersAsStrings(true)
+
+	bigFloat, _ := new(big.Float).SetPrec(200).SetString("0.1")
+	err := wr.WriteArrayStart()
+	assert.NoError(t, err)
+	assert.NoError(t, wr.WriteInt64Value(MAX_SAFE_INTEGER))
+	assert.NoError(t, wr.WriteInt64Value(-MAX_SAFE_INTEGER-1))
+	assert.NoError(t, wr.WriteUint64Value(MAX_SAFE_INTEGER+1))
+	assert.NoError(t, wr.WriteBigIntValue(big.NewInt(42)))
+	assert.NoError(t, wr.WriteBigIntValue(new(big.Int).Lsh(big.NewInt(1), 64)))
+	assert.NoError(t, wr.WriteBigFloatValue(big.NewFloat(0.5)))
+	assert.NoError(t, wr.WriteBigFloatValue(bigFloat))
+	err = wr.WriteArrayEnd()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "[9007199254740991,\"-9007199254740992\",\"9007199254740992\",42,\"18446744073709551616\",0.5,\"0.1\"]", buf.String())
+}
+
+func TestWritesValidatedRawValues(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndRawValue("a", []byte(" { \"b\" : [1.50, true, \"x\"] } ")))
+	assert.NoError(t, wr.WriteKeyAndRawValue("c", []byte("null")))
+	assert.NoError(t, wr.WriteObjectEnd())
+
+	assert.Equal(t, "{\"a\":{\"b\":[1.50,true,\"x\"]},\"c\":null}", buf.String())
+}
+
+func TestReindentsRawValues(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetIndent("\t")
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndRawValue("a", []byte("{\"b\":   \"c\"}")))
+	assert.NoError(t, wr.WriteObjectEnd())
+
+	assert.Equal(t, "{\n\t\"a\": {\n\t\t\"b\": \"c\"\n\t}\n}", buf.String())
+}
+
+func TestFailsOnInvalidRawValueWithoutWriting(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	err := wr.WriteRawValue([]byte("{\"a\":1"))
+	assert.EqualError(t, err, "invalid raw value: unexpected end of input in TRS_IN_OBJECT_PAIR_SEEN at line 1, column 7 (offset 6, path $.a)")
+	assert.Equal(t, "[", buf.String())
+
+	wr = NewTokenWriter(buf)
+	err = wr.WriteRawValue([]byte("1 2"))
+	assert.EqualError(t, err, "invalid raw value: unexpected character '2' after top-level value at line 1, column 3 (offset 2, path $)")
+	assert.Equal(t, "[", buf.String())
+}
+
+func TestWritesUnvalidatedRawValuesAsIs(t *testing.T) {
+	tokens := []Token{{Type: TT_ARRAY_START, Value: ""}, {Type: TT_RAW_VALUE, Value: "{\"a\":  1}"}, {Type: TT_RAW_VALUE, Value: "2"}, {Type: TT_ARRAY_END, Value: ""}}
+	testProducesJsonViaTokenStream(t, "", "[{\"a\":  1},2]", tokens...)
+
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetValidateRawValues(false)
+	assert.NoError(t, wr.WriteRawValue([]byte(" [1,  2] \n")))
+	assert.Equal(t, "[1,  2]", buf.String())
+}
+
+type countingWriter struct {
+	bytes.Buffer
+	writes  int
+	flushes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+func (c *countingWriter) Flush() {
+	c.flushes++
+}
+
+func TestWritesEveryTokenWithSingleWrite(t *testing.T) {
+	dest := &countingWriter{}
+	wr := NewTokenWriter(dest)
+	wr.SetIndent("\t\t")
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteStringValue("a"))
+	assert.NoError(t, wr.WriteStringValue("b"))
+	assert.NoError(t, wr.WriteArrayEnd())
+
+	assert.Equal(t, "[\n\t\t\"a\",\n\t\t\"b\"\n]", dest.String())
+	assert.Equal(t, 5, dest.writes)
+}
+
+func TestBuffersOutputUntilClose(t *testing.T) {
+	dest := &countingWriter{}
+	wr := NewTokenWriter(dest)
+	assert.NoError(t, wr.SetBufferSize(DEFAULT_BUFFER_SIZE))
+
+	assert.NoError(t, wr.WriteArrayStart())
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, wr.WriteIntegerValue(i))
+	}
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.Equal(t, 0, dest.writes)
+
+	assert.NoError(t, wr.Close())
+	assert.Equal(t, 1, dest.writes)
+	assert.Equal(t, 1, dest.flushes)
+	assert.True(t, strings.HasPrefix(dest.String(), "[0,1,2,"))
+}
+
+func TestFlushesOnTopLevelItems(t *testing.T) {
+	dest := &countingWriter{}
+	wr := NewTokenWriter(dest)
+	assert.NoError(t, wr.SetBufferSize(DEFAULT_BUFFER_SIZE))
+	wr.SetFlushDepth(1)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.Equal(t, "", dest.String())
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.Equal(t, "[{\"a\":1}", dest.String())
+	assert.NoError(t, wr.WriteStringValue("b"))
+	assert.Equal(t, "[{\"a\":1},\"b\"", dest.String())
+	assert.Equal(t, 2, dest.flushes)
+}
+
+func TestFlushesExplicitly(t *testing.T) {
+	dest := &countingWriter{}
+	wr := NewTokenWriter(dest)
+	assert.NoError(t, wr.SetBufferSize(16))
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.Equal(t, "", dest.String())
+	assert.NoError(t, wr.Flush())
+	assert.Equal(t, "[", dest.String())
+}
+
+type failingWriter struct {
+	bytes.Buffer
+	failAfter int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.failAfter <= 0 {
+		return 0, errors.New("disk full")
+	}
+	f.failAfter--
+	return f.Buffer.Write(p)
+}
+
+func TestRecordsFirstWriteErrorAndKeepsState(t *testing.T) {
+	dest := &failingWriter{failAfter: 2}
+	wr := NewTokenWriter(dest)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.EqualError(t, wr.WriteStringValue("b"), "disk full")
+	assert.Equal(t, TWS_IN_OBJECT_KEY_SEEN, wr.stateStack.Peek())
+
+	dest.failAfter = 10
+	assert.EqualError(t, wr.WriteStringValue("c"), "disk full")
+	assert.EqualError(t, wr.WriteObjectEnd(), "disk full")
+	assert.EqualError(t, wr.Flush(), "disk full")
+	assert.EqualError(t, wr.Err(), "disk full")
+	assert.Equal(t, "{\"a\"", dest.String())
+}
+
+func TestRecordsFirstValueError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetInvalidUTF8Policy(IUP_ERROR)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteStringValue("a"))
+	assert.EqualError(t, wr.WriteStringValue("\xff"), "invalid UTF-8 at byte offset 0")
+	assert.EqualError(t, wr.WriteNumberValue(math.NaN()), "invalid UTF-8 at byte offset 0")
+	assert.EqualError(t, wr.WriteValue([]int{1}), "invalid UTF-8 at byte offset 0")
+	assert.EqualError(t, wr.Close(), "invalid UTF-8 at byte offset 0")
+	assert.Equal(t, "[\"a\"", buf.String())
+}
+
+type closingWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (c *closingWriter) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCloseChecksEndStateAndClosesOwnedDestination(t *testing.T) {
+	dest := &closingWriter{}
+	wr := NewTokenWriter(dest)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.EqualError(t, wr.Close(), "not in end state")
+	assert.True(t, dest.closed)
+}
+
+func TestCloseLeavesForeignDestinationOpen(t *testing.T) {
+	dest := &closingWriter{}
+	wr := NewTokenWriter(dest)
+	wr.SetOwnsDestination(false)
+
+	assert.NoError(t, wr.WriteNullValue())
+	assert.NoError(t, wr.Close())
+	assert.False(t, dest.closed)
+}
+
+func TestFinishChecksEndStateWithoutClosing(t *testing.T) {
+	dest := &closingWriter{}
+	wr := NewTokenWriter(dest)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.EqualError(t, wr.Finish(), "not in end state")
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Finish())
+	assert.False(t, dest.closed)
+	assert.Equal(t, "{}", dest.String())
+}
+
+func TestCloseAndCompleteEndsOpenContainers(t *testing.T) {
+	dest := &closingWriter{}
+	wr := NewTokenWriter(dest)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.CloseAndComplete())
+
+	assert.Equal(t, "[1,{\"a\":null}]", dest.String())
+	assert.True(t, dest.closed)
+}
+
+func TestCloseAndCompleteAddsMemberAfterComma(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteToken(Token{Type: TT_COMMA}))
+	assert.NoError(t, wr.CloseAndComplete())
+
+	assert.Equal(t, "{\"a\":1,\"_error\":null}", buf.String())
+}
+
+func TestAbortWritesMarkerAfterComma(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteToken(Token{Type: TT_COMMA}))
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, "{\"a\":1,\"_error\":\"db gone\"}", buf.String())
+}
+
+func TestCloseAndCompleteWritesNullIfNothingWritten(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.CloseAndComplete())
+	assert.Equal(t, "null", buf.String())
+}
+
+func TestAbortWritesMarkerIntoInnermostObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("b"))
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, "[{\"a\":1,\"b\":null,\"_error\":\"db gone\"}]", buf.String())
+	assert.NoError(t, wr.Finish())
+}
+
+func TestAbortAppendsMarkerToInnermostArray(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetAbortMarkerKey("failure")
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKey("items"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(1))
+	assert.NoError(t, wr.Abort(map[string]int{"code": 500}))
+
+	assert.Equal(t, "{\"items\":[1,{\"failure\":{\"code\":500}}]}", buf.String())
+}
+
+func TestAbortWithoutMarkerOnlyEndsContainers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.Abort(nil))
+
+	assert.Equal(t, "[[]]", buf.String())
+}
+
+func TestAbortCompletesOutputAfterValueError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	err := wr.WriteKeyAndValue("a", []interface{}{1, make(chan int)})
+	assert.EqualError(t, err, "unsupported type chan int")
+	assert.NoError(t, wr.Abort(err))
+
+	assert.Equal(t, "{\"a\":[1,{\"_error\":\"unsupported type chan int\"}]}", buf.String())
+	assert.EqualError(t, wr.Err(), "unsupported type chan int")
+}
+
+func TestAbortWritesMarkerBeyondMaxDepth(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxDepth: 1})
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, "[{\"_error\":\"db gone\"}]", buf.String())
+}
+
+func TestAbortWritesMarkerBeyondMaxKeysPerObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxKeysPerObject: 1})
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, "{\"a\":1,\"_error\":\"db gone\"}", buf.String())
+}
+
+func TestAbortWritesMarkerBeyondMaxStringLength(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxStringLength: 5})
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.Abort(errors.New("database gone")))
+
+	assert.Equal(t, "{\"_error\":\"database gone\"}", buf.String())
+}
+
+func TestAbortWritesMarkerWithUnusedKey(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_ERROR)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("_error", 1))
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, "{\"_error\":1,\"_error_2\":\"db gone\"}", buf.String())
+}
+
+func TestAbortCompletesOutputIfMarkerFails(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.Abort(make(chan int)))
+
+	assert.Equal(t, "{\"_error\":null}", buf.String())
+	assert.NoError(t, wr.Err())
+}
+
+func TestAbortWritesMarkerAfterDroppedMember(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_DROP)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.Abort(errors.New("db gone")))
+
+	assert.Equal(t, "{\"a\":1,\"_error\":\"db gone\"}", buf.String())
+}
+
+func TestCloseAndCompleteAddsUnusedKeyAfterComma(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_ERROR)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("_error", 1))
+	assert.NoError(t, wr.WriteToken(Token{Type: TT_COMMA}))
+	assert.NoError(t, wr.CloseAndComplete())
+
+	assert.Equal(t, "{\"_error\":1,\"_error_2\":null}", buf.String())
+}
+
+func TestAbortFailsAfterDestinationFailed(t *testing.T) {
+	dest := &failingWriter{failAfter: 1}
+	wr := NewTokenWriter(dest)
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.EqualError(t, wr.WriteNullValue(), "disk full")
+	assert.EqualError(t, wr.Abort(nil), "disk full")
+	assert.Equal(t, "[", dest.String())
+}
+
+func TestFailsWritingDuplicateKey(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_ERROR)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("b"))
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 2))
+	assert.NoError(t, wr.WriteObjectEnd())
+	err := wr.WriteKeyAndIntegerValue("a", 3)
+
+	var duplicateKeyErr *DuplicateKeyError
+	assert.True(t, errors.As(err, &duplicateKeyErr))
+	assert.EqualError(t, err, "duplicate key \"a\" at $.a")
+	assert.Equal(t, "{\"a\":1,\"b\":{\"a\":2}", buf.String())
+}
+
+func TestDropsMembersWithDuplicateKey(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_DROP)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKeyAndValue("a", map[string][]int{"x": {1}}))
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("b", 2))
+	assert.NoError(t, wr.WriteKeyAndRawValue("b", []byte("[3]")))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"a\":1,\"b\":2}", buf.String())
+}
+
+func TestWritesObjectEndAfterDroppedKey(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_DROP)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.WriteObjectEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"a\":1}", buf.String())
+}
+
+func TestChecksDroppedMembers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_DROP)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.EqualError(t, wr.WriteKey("x"), "TT_KEY not allowed in TWS_IN_ARRAY")
+}
+
+func TestCompletesDroppedMember(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetDuplicateKeyPolicy(DKP_DROP)
+
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndIntegerValue("a", 1))
+	assert.NoError(t, wr.WriteKey("a"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.CloseAndComplete())
+
+	assert.Equal(t, "{\"a\":1}", buf.String())
+}
+
+func TestFailsWritingBeyondLimits(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxDepth: 1})
+	assert.NoError(t, wr.WriteArrayStart())
+	err := wr.WriteArrayStart()
+	var depthErr *MaxDepthError
+	assert.True(t, errors.As(err, &depthErr))
+	assert.EqualError(t, err, "maximum depth of 1 exceeded at $[0]")
+
+	wr = NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxStringLength: 2})
+	err = wr.WriteStringValue("abc")
+	var lengthErr *MaxStringLengthError
+	assert.True(t, errors.As(err, &lengthErr))
+	assert.EqualError(t, err, "maximum string length of 2 exceeded at $")
+
+	wr = NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxKeysPerObject: 1})
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.NoError(t, wr.WriteKeyAndNullValue("a"))
+	err = wr.WriteKey("b")
+	var keysErr *MaxKeysPerObjectError
+	assert.True(t, errors.As(err, &keysErr))
+	assert.EqualError(t, err, "maximum number of 1 keys per object exceeded at $.b")
+}
+
+func TestFailsWritingMoreThanMaxTotalBytes(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetLimits(Limits{MaxTotalBytes: 6})
+
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteIntegerValue(12))
+	err := wr.WriteIntegerValue(345)
+
+	var sizeErr *MaxTotalBytesError
+	assert.True(t, errors.As(err, &sizeErr))
+	assert.EqualError(t, err, "maximum size of 6 bytes exceeded at $[1]")
+	assert.Equal(t, "[12,", buf.String())
+}
+
+func TestWritesMultipleDocuments(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetMultiDocument(true)
+
+	assert.NoError(t, wr.WriteValue(map[string]int{"a": 1}))
+	assert.NoError(t, wr.WriteStringValue("b"))
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.WriteArrayEnd())
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "{\"a\":1}\n\"b\"\n[]\n", buf.String())
+	assert.Equal(t, int64(3), wr.DocumentCount())
+}
+
+func TestWritesMultipleDocumentsWithSeparator(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetMultiDocument(true)
+	wr.SetDocumentSeparator("\r\n")
+	wr.SetIndent("  ")
+
+	assert.NoError(t, wr.WriteValue([]int{1}))
+	assert.NoError(t, wr.WriteValue([]int{2}))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "[\n  1\n]\r\n[\n  2\n]\r\n", buf.String())
+}
+
+func TestFailsOnCloseWithOpenDocument(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetMultiDocument(true)
+
+	assert.NoError(t, wr.WriteNullValue())
+	assert.NoError(t, wr.WriteObjectStart())
+	assert.EqualError(t, wr.Close(), "not in end state")
+	assert.Equal(t, int64(1), wr.DocumentCount())
+}
+
+func TestCompletesOpenDocumentOnly(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetMultiDocument(true)
+
+	assert.NoError(t, wr.WriteNullValue())
+	assert.NoError(t, wr.WriteArrayStart())
+	assert.NoError(t, wr.CloseAndComplete())
+
+	assert.Equal(t, "null\n[]\n", buf.String())
+}
+
+func TestWritesJSONTextSequence(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetJSONTextSequence(true)
+
+	assert.NoError(t, wr.WriteValue(map[string]int{"a": 1}))
+	assert.NoError(t, wr.WriteIntegerValue(2))
+	assert.NoError(t, wr.Close())
+
+	assert.Equal(t, "\x1e{\"a\":1}\n\x1e2\n", buf.String())
+}
+
+func TestAcceptsDocumentEndAfterCompleteDocument(t *testing.T) {
+	buf := new(bytes.Buffer)
+	wr := NewTokenWriter(buf)
+	wr.SetMultiDocument(true)
+
+	assert.NoError(t, wr.WriteTokens(Token{Type: TT_TRUE_VALUE}, Token{Type: TT_DOCUMENT_END}, Token{Type: TT_ARRAY_START}))
+	assert.EqualError(t, wr.WriteToken(Token{Type: TT_DOCUMENT_END}), "TT_DOCUMENT_END not allowed in TWS_IN_ARRAY")
+	assert.Equal(t, "true\n[", buf.String())
+}